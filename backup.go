@@ -0,0 +1,14 @@
+package tinyq
+
+import "io"
+
+// Backup writes a consistent snapshot of the app to w while it keeps being
+// used. The snapshot can be restored with StageRestore.
+func (s *tinyQ) Backup(w io.Writer) (int64, error) {
+	snap, ok := s.db.(snapshotter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	return snap.Snapshot(w)
+}
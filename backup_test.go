@@ -0,0 +1,69 @@
+package tinyq
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	q := openapp(t, &Options{Appname: "queue", Rootpath: t.TempDir()})
+	push(t, q, "jobs", "a", "one")
+	push(t, q, "jobs", "b", "two", WithPriority(MaxPriority))
+	if err := q.Set("settings", "color", "blue"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := q.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot does not follow later writes.
+	push(t, q, "jobs", "c", "three")
+
+	opt := &Options{Appname: "restored", Rootpath: t.TempDir()}
+	staged, err := StageRestore(opt, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(staged, DBPath(opt)); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := openapp(t, opt)
+	items, err := restored.PopItems("jobs", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 2 || items[0].Key != "b" || items[1].Key != "a" {
+		t.Fatalf("restored jobs popped %v, want b then a", items)
+	}
+
+	if color, err := restored.Get("settings", "color"); err != nil || color != "blue" {
+		t.Fatalf("restored color %q: %v", color, err)
+	}
+}
+
+func TestStageRestoreInvalid(t *testing.T) {
+	opt := &Options{Appname: "restored", Rootpath: t.TempDir()}
+	if _, err := StageRestore(opt, strings.NewReader("not a database")); err == nil {
+		t.Fatal("staged a snapshot that is not a database")
+	}
+
+	// Nothing is left behind for a later restore to pick up.
+	if entries, _ := os.ReadDir(opt.Root()); len(entries) != 0 {
+		t.Fatalf("left %d files in the app root", len(entries))
+	}
+}
+
+func TestBackupMemory(t *testing.T) {
+	q := newtestq(t, nil)
+	if _, err := q.Backup(&bytes.Buffer{}); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("backup of a memory app returned %v, want ErrNotSupported", err)
+	}
+}
@@ -0,0 +1,38 @@
+package tinyq
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkPush pushes from concurrent producers at each durability level.
+// Batched pushes share their syncs, so they gain the most with many producers:
+//
+//	go test -run '^$' -bench Push .
+func BenchmarkPush(b *testing.B) {
+	for _, durability := range []Durability{DurabilitySync, DurabilityBatch, DurabilityNone} {
+		b.Run(string(durability), func(b *testing.B) {
+			Rootpath = b.TempDir()
+
+			q := NewTinyQ(&Options{Appname: "bench", Durability: durability})
+			if err := q.Open(); err != nil {
+				b.Fatal(err)
+			}
+			defer q.Close()
+
+			var n atomic.Int64
+			b.SetParallelism(64)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := strconv.FormatInt(n.Add(1), 10)
+					if err := q.PushItem(&Item{Channel: "bench", Key: key, Payload: []byte("payload")}); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,633 @@
+package tinyq
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The changelog of an app records the writes of every committed transaction
+// as a numbered change, in the same transaction. A replica applies the
+// changes in order to its own copy, which then holds the same buckets, keys
+// and sequences as the app it follows.
+
+const (
+	bucketChangelog     = "internal:changelog"
+	bucketChangelogMeta = "internal:changelog_meta"
+
+	// defaultChangelogSize is how many changes are kept for replicas that
+	// fall behind. A replica further behind is restored from a backup.
+	defaultChangelogSize = 100000
+	defaultChangesLimit  = 1000
+)
+
+var keyChangelogID = []byte("id")
+
+// ErrReplica is returned for a write to an app that is a read-only replica.
+var ErrReplica = errors.New("app is a read-only replica")
+
+// ErrChangelogGap is returned when the changes a replica needs are no longer
+// or not yet in the changelog, or belong to another changelog. The replica
+// must be restored from a backup.
+var ErrChangelogGap = errors.New("changelog gap, replica must be restored")
+
+var ErrNoChangelog = errors.New("changelog is not enabled")
+
+// ErrNotReplica is returned for changes applied to an app that is not a
+// replica, such as one that was promoted meanwhile.
+var ErrNotReplica = errors.New("app is not a replica")
+
+const (
+	opPut          = "put"
+	opDelete       = "delete"
+	opCreateBucket = "create_bucket"
+	opDeleteBucket = "delete_bucket"
+	opSequence     = "sequence"
+)
+
+// ChangeOp is a single write of a change.
+type ChangeOp struct {
+	Op       string `json:"op"`
+	Bucket   []byte `json:"bucket"`
+	Key      []byte `json:"key,omitempty"`
+	Value    []byte `json:"value,omitempty"`
+	Sequence uint64 `json:"sequence,omitempty"`
+}
+
+// Change holds the writes of a committed transaction.
+type Change struct {
+	Seq  uint64      `json:"seq"`
+	Time time.Time   `json:"time"`
+	Ops  []*ChangeOp `json:"ops"`
+}
+
+// ChangeSet is a page of the changelog of an app, along with its last change.
+type ChangeSet struct {
+	ID       string    `json:"id"`
+	Head     uint64    `json:"head"`
+	HeadTime time.Time `json:"head_time"`
+	Changes  []*Change `json:"changes"`
+}
+
+// ChangelogPosition is the last change of a changelog. ID tells changelogs
+// apart, so a replica never applies the changes of another app.
+type ChangelogPosition struct {
+	ID   string    `json:"id"`
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+}
+
+func seqkey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// changelogstore records the writes of its transactions. While it is a
+// replica, it refuses every write but the changes it applies.
+type changelogstore struct {
+	Store
+	notifier *notifier
+	replica  atomic.Bool
+}
+
+func (s *changelogstore) Update(fn func(Tx) error) error {
+	if s.replica.Load() {
+		return ErrReplica
+	}
+
+	return s.Store.Update(s.record(fn))
+}
+
+func (s *changelogstore) Batch(fn func(Tx) error) error {
+	if s.replica.Load() {
+		return ErrReplica
+	}
+
+	return s.Store.Batch(s.record(fn))
+}
+
+// Snapshot includes the changelog, so a replica restored from it resumes
+// right after the last change it holds.
+func (s *changelogstore) Snapshot(w io.Writer) (int64, error) {
+	snap, ok := s.Store.(snapshotter)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	return snap.Snapshot(w)
+}
+
+func (s *changelogstore) record(fn func(Tx) error) func(Tx) error {
+	return func(tx Tx) error {
+		rec := &recordingtx{Tx: tx}
+		if err := fn(rec); err != nil {
+			return err
+		}
+
+		if len(rec.ops) == 0 {
+			return nil
+		}
+
+		return s.append(tx, &Change{Time: time.Now(), Ops: rec.ops})
+	}
+}
+
+// append writes a change to the changelog, numbering it unless it comes
+// numbered from the changelog a replica follows.
+func (s *changelogstore) append(tx Tx, change *Change) error {
+	log, err := tx.CreateBucketIfNotExists([]byte(bucketChangelog))
+	if err != nil {
+		return err
+	}
+
+	if change.Seq == 0 {
+		if change.Seq, err = log.NextSequence(); err != nil {
+			return err
+		}
+	} else if err := log.SetSequence(change.Seq); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	if err := log.Put(seqkey(change.Seq), value); err != nil {
+		return err
+	}
+
+	tx.OnCommit(func() {
+		s.notifier.notify(bucketChangelog)
+	})
+
+	return nil
+}
+
+// ensureid gives the changelog an ID the first time it is used.
+func (s *changelogstore) ensureid() error {
+	return s.Store.Update(func(tx Tx) error {
+		meta, err := tx.CreateBucketIfNotExists([]byte(bucketChangelogMeta))
+		if err != nil {
+			return err
+		}
+
+		if meta.Get(keyChangelogID) != nil {
+			return nil
+		}
+
+		return meta.Put(keyChangelogID, []byte(uuid.NewString()))
+	})
+}
+
+func position(tx Tx) (*ChangelogPosition, error) {
+	pos := &ChangelogPosition{}
+	if meta := tx.Bucket([]byte(bucketChangelogMeta)); meta != nil {
+		pos.ID = string(meta.Get(keyChangelogID))
+	}
+
+	log := tx.Bucket([]byte(bucketChangelog))
+	if log == nil {
+		return pos, nil
+	}
+
+	k, v := log.Cursor().Last()
+	if k == nil {
+		return pos, nil
+	}
+
+	var change Change
+	if err := json.Unmarshal(v, &change); err != nil {
+		return nil, err
+	}
+
+	pos.Seq, pos.Time = change.Seq, change.Time
+	return pos, nil
+}
+
+// recordingtx records the writes made through it and the buckets it returns.
+type recordingtx struct {
+	Tx
+	ops []*ChangeOp
+}
+
+func (t *recordingtx) add(op *ChangeOp) {
+	t.ops = append(t.ops, op)
+}
+
+func (t *recordingtx) wrap(name []byte, b Bucket) Bucket {
+	if b == nil {
+		return nil
+	}
+
+	return &recordingbucket{Bucket: b, tx: t, name: bytes.Clone(name)}
+}
+
+func (t *recordingtx) Bucket(name []byte) Bucket {
+	return t.wrap(name, t.Tx.Bucket(name))
+}
+
+func (t *recordingtx) CreateBucket(name []byte) (Bucket, error) {
+	b, err := t.Tx.CreateBucket(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t.add(&ChangeOp{Op: opCreateBucket, Bucket: bytes.Clone(name)})
+	return t.wrap(name, b), nil
+}
+
+func (t *recordingtx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if b := t.Bucket(name); b != nil {
+		return b, nil
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *recordingtx) DeleteBucket(name []byte) error {
+	if err := t.Tx.DeleteBucket(name); err != nil {
+		return err
+	}
+
+	t.add(&ChangeOp{Op: opDeleteBucket, Bucket: bytes.Clone(name)})
+	return nil
+}
+
+func (t *recordingtx) ForEach(fn func(name []byte, b Bucket) error) error {
+	return t.Tx.ForEach(func(name []byte, b Bucket) error {
+		return fn(name, t.wrap(name, b))
+	})
+}
+
+type recordingbucket struct {
+	Bucket
+	tx   *recordingtx
+	name []byte
+}
+
+func (b *recordingbucket) Put(key, value []byte) error {
+	if err := b.Bucket.Put(key, value); err != nil {
+		return err
+	}
+
+	b.tx.add(&ChangeOp{Op: opPut, Bucket: b.name, Key: bytes.Clone(key), Value: bytes.Clone(value)})
+	return nil
+}
+
+func (b *recordingbucket) Delete(key []byte) error {
+	if err := b.Bucket.Delete(key); err != nil {
+		return err
+	}
+
+	b.tx.add(&ChangeOp{Op: opDelete, Bucket: b.name, Key: bytes.Clone(key)})
+	return nil
+}
+
+func (b *recordingbucket) Cursor() Cursor {
+	return &recordingcursor{Cursor: b.Bucket.Cursor(), b: b}
+}
+
+func (b *recordingbucket) NextSequence() (uint64, error) {
+	seq, err := b.Bucket.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+
+	b.tx.add(&ChangeOp{Op: opSequence, Bucket: b.name, Sequence: seq})
+	return seq, nil
+}
+
+func (b *recordingbucket) SetSequence(v uint64) error {
+	if err := b.Bucket.SetSequence(v); err != nil {
+		return err
+	}
+
+	b.tx.add(&ChangeOp{Op: opSequence, Bucket: b.name, Sequence: v})
+	return nil
+}
+
+// recordingcursor remembers the key it is on to record its deletion.
+type recordingcursor struct {
+	Cursor
+	b   *recordingbucket
+	key []byte
+}
+
+func (c *recordingcursor) at(k, v []byte) ([]byte, []byte) {
+	c.key = k
+	return k, v
+}
+
+func (c *recordingcursor) First() ([]byte, []byte) { return c.at(c.Cursor.First()) }
+func (c *recordingcursor) Last() ([]byte, []byte)  { return c.at(c.Cursor.Last()) }
+func (c *recordingcursor) Next() ([]byte, []byte)  { return c.at(c.Cursor.Next()) }
+func (c *recordingcursor) Prev() ([]byte, []byte)  { return c.at(c.Cursor.Prev()) }
+
+func (c *recordingcursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.at(c.Cursor.Seek(seek))
+}
+
+func (c *recordingcursor) Delete() error {
+	if c.key == nil {
+		return nil
+	}
+
+	key := bytes.Clone(c.key)
+	if err := c.Cursor.Delete(); err != nil {
+		return err
+	}
+
+	c.b.tx.add(&ChangeOp{Op: opDelete, Bucket: c.b.name, Key: key})
+	return nil
+}
+
+func applyop(tx Tx, op *ChangeOp) error {
+	switch op.Op {
+	case opCreateBucket:
+		_, err := tx.CreateBucketIfNotExists(op.Bucket)
+		return err
+	case opDeleteBucket:
+		if err := tx.DeleteBucket(op.Bucket); err != ErrBucketNotFound {
+			return err
+		}
+		return nil
+	}
+
+	b := tx.Bucket(op.Bucket)
+	if b == nil {
+		return fmt.Errorf("change on missing bucket %q", op.Bucket)
+	}
+
+	switch op.Op {
+	case opPut:
+		value := op.Value
+		if value == nil {
+			value = []byte{}
+		}
+		return b.Put(op.Key, value)
+	case opDelete:
+		return b.Delete(op.Key)
+	case opSequence:
+		return b.SetSequence(op.Sequence)
+	}
+
+	return fmt.Errorf("unknown change op %q", op.Op)
+}
+
+func (s *tinyQ) changelog() (*changelogstore, error) {
+	cl, ok := s.db.(*changelogstore)
+	if !ok {
+		return nil, ErrNoChangelog
+	}
+
+	return cl, nil
+}
+
+// replica reports whether the app refuses writes as a replica.
+func (s *tinyQ) replica() bool {
+	cl, ok := s.db.(*changelogstore)
+	return ok && cl.replica.Load()
+}
+
+// ChangelogPosition returns the last change recorded or applied by the app.
+func (s *tinyQ) ChangelogPosition() (*ChangelogPosition, error) {
+	if _, err := s.changelog(); err != nil {
+		return nil, err
+	}
+
+	var pos *ChangelogPosition
+	err := s.db.View(func(tx Tx) error {
+		var err error
+		pos, err = position(tx)
+		return err
+	})
+
+	return pos, err
+}
+
+// Changes returns up to limit changes recorded after the change numbered
+// after. When there are none yet, it waits for one like PopWait does. It
+// returns ErrChangelogGap when the changes were trimmed from the changelog
+// or after is beyond its last change.
+func (s *tinyQ) Changes(ctx context.Context, after uint64, limit int, wait time.Duration) (*ChangeSet, error) {
+	if _, err := s.changelog(); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultChangesLimit
+	}
+
+	var expired <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	done := s.closing()
+	for {
+		wake := s.notifier.wait(bucketChangelog)
+
+		set, err := s.readchanges(after, limit)
+		if err != nil || len(set.Changes) > 0 || wait <= 0 {
+			return set, err
+		}
+
+		select {
+		case <-wake:
+		case <-expired:
+			return set, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-done:
+			return set, nil
+		}
+	}
+}
+
+func (s *tinyQ) readchanges(after uint64, limit int) (*ChangeSet, error) {
+	set := &ChangeSet{}
+	err := s.db.View(func(tx Tx) error {
+		pos, err := position(tx)
+		if err != nil {
+			return err
+		}
+
+		set.ID, set.Head, set.HeadTime = pos.ID, pos.Seq, pos.Time
+		if after > pos.Seq {
+			return ErrChangelogGap
+		}
+
+		log := tx.Bucket([]byte(bucketChangelog))
+		if log == nil {
+			return nil
+		}
+
+		c := log.Cursor()
+		if k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) > after+1 {
+			return ErrChangelogGap
+		}
+
+		for k, v := c.Seek(seqkey(after + 1)); k != nil && len(set.Changes) < limit; k, v = c.Next() {
+			var change Change
+			if err := json.Unmarshal(v, &change); err != nil {
+				return err
+			}
+			set.Changes = append(set.Changes, &change)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// ApplyChanges applies the changes of the changelog a replica follows, in
+// order and in a single transaction. Changes it already holds are skipped.
+// It returns ErrChangelogGap when the set comes from another changelog or
+// misses changes, in which case the replica must be restored from a backup.
+func (s *tinyQ) ApplyChanges(set *ChangeSet) error {
+	cl, err := s.changelog()
+	if err != nil {
+		return err
+	}
+
+	return cl.Store.Update(func(tx Tx) error {
+		// Checked in the transaction, as Promote changes it in one.
+		if !cl.replica.Load() {
+			return ErrNotReplica
+		}
+
+		pos, err := position(tx)
+		if err != nil {
+			return err
+		}
+
+		if pos.ID != set.ID || pos.Seq > set.Head {
+			return ErrChangelogGap
+		}
+
+		for _, change := range set.Changes {
+			if change.Seq <= pos.Seq {
+				continue
+			}
+
+			if change.Seq != pos.Seq+1 {
+				return ErrChangelogGap
+			}
+
+			for _, op := range change.Ops {
+				if err := applyop(tx, op); err != nil {
+					return err
+				}
+			}
+
+			if err := cl.append(tx, change); err != nil {
+				return err
+			}
+
+			pos.Seq = change.Seq
+		}
+
+		return nil
+	})
+}
+
+// TrimChangelog drops the oldest changes beyond the changelog size.
+func (s *tinyQ) TrimChangelog() (int, error) {
+	cl, err := s.changelog()
+	if err != nil {
+		return 0, err
+	}
+
+	size := s.opt.ChangelogSize
+	if size <= 0 {
+		size = defaultChangelogSize
+	}
+
+	var trimmed int
+	err = cl.Store.Update(func(tx Tx) error {
+		trimmed = 0
+		log := tx.Bucket([]byte(bucketChangelog))
+		if log == nil {
+			return nil
+		}
+
+		// Keys are collected first, as deleting moves a bolt cursor.
+		var keys [][]byte
+		excess := log.KeyN() - size
+		c := log.Cursor()
+		for k, _ := c.First(); k != nil && len(keys) < excess; k, _ = c.Next() {
+			keys = append(keys, bytes.Clone(k))
+		}
+
+		for _, k := range keys {
+			if err := log.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		trimmed = len(keys)
+		return nil
+	})
+
+	return trimmed, err
+}
+
+// Promote makes a replica writable. Its changelog carries on from the last
+// change it applied, so other replicas can follow it instead.
+func (s *tinyQ) Promote() error {
+	cl, err := s.changelog()
+	if err != nil {
+		return err
+	}
+
+	if err := cl.ensureid(); err != nil {
+		return err
+	}
+
+	// Changes being applied are committed before the replica is promoted.
+	return cl.Store.Update(func(tx Tx) error {
+		cl.replica.Store(false)
+		return nil
+	})
+}
+
+// ReplicationStats describe the replication of the apps of a server.
+type ReplicationStats struct {
+	// Role is "leader" for a server recording changes, "follower" for one
+	// applying them, and "none" otherwise.
+	Role string `json:"role"`
+	// Leader is the url of the server a follower replicates.
+	Leader string                     `json:"leader,omitempty"`
+	Apps   map[string]*AppReplication `json:"apps"`
+}
+
+// AppReplication is how far the copy of an app is behind its leader.
+type AppReplication struct {
+	// Applied is the last change the app holds, Head the last change of its
+	// leader when it was last contacted.
+	Applied uint64 `json:"applied"`
+	Head    uint64 `json:"head"`
+	// Behind is the number of changes not applied yet, and LagSeconds how
+	// much older the last applied change is than the head.
+	Behind      uint64    `json:"behind"`
+	LagSeconds  float64   `json:"lag_seconds"`
+	LastContact time.Time `json:"last_contact,omitzero"`
+	Error       string    `json:"error,omitempty"`
+}
@@ -0,0 +1,162 @@
+package tinyq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func openapp(t *testing.T, opt *Options) TinyQ {
+	t.Helper()
+
+	q := NewTinyQ(opt)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+
+	return q
+}
+
+// replicate sets up a replica of leader in its own root path, the way a
+// follower resyncs: from a backup, which carries the changelog ID.
+func replicate(t *testing.T, leader TinyQ, appname string) TinyQ {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if _, err := leader.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	opt := &Options{Appname: appname, Rootpath: t.TempDir(), Replica: true}
+	staged, err := StageRestore(opt, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(staged, DBPath(opt)); err != nil {
+		t.Fatal(err)
+	}
+
+	return openapp(t, opt)
+}
+
+// catchup applies the changes of leader to replica until it holds them all.
+func catchup(t *testing.T, leader, replica TinyQ) {
+	t.Helper()
+
+	for {
+		pos, err := replica.ChangelogPosition()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		set, err := leader.Changes(context.Background(), pos.Seq, 2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(set.Changes) == 0 {
+			return
+		}
+
+		if err := replica.ApplyChanges(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReplication(t *testing.T) {
+	leader := openapp(t, &Options{Appname: "orders", Rootpath: t.TempDir(), Changelog: true})
+
+	push(t, leader, "new", "a", "one")
+	push(t, leader, "new", "b", "two")
+	replica := replicate(t, leader, "orders")
+
+	// Changes made after the backup reach the replica through the changelog.
+	push(t, leader, "new", "c", "three")
+	push(t, leader, "paid", "d", "four")
+	items, err := leader.PopItems("new", 1)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("pop returned %v, %v", items, err)
+	}
+	if err := leader.AckItem(items[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.Set("settings", "color", "blue"); err != nil {
+		t.Fatal(err)
+	}
+
+	catchup(t, leader, replica)
+
+	want, _ := leader.ChangelogPosition()
+	got, _ := replica.ChangelogPosition()
+	if got.ID != want.ID || got.Seq != want.Seq {
+		t.Fatalf("replica at %+v, leader at %+v", got, want)
+	}
+
+	for channel, n := range map[string]int{"new": 2, "paid": 1} {
+		if c, _ := replica.Count(channel); c != n {
+			t.Fatalf("replica holds %d items in %s, want %d", c, channel, n)
+		}
+	}
+
+	if v, _ := replica.Get("settings", "color"); v != "blue" {
+		t.Fatalf("replica setting is %q", v)
+	}
+
+	// A replica is only written by the changes it applies.
+	if err := replica.PushItem(&Item{Channel: "new", Key: "x", Payload: []byte("x")}); !errors.Is(err, ErrReplica) {
+		t.Fatalf("push to the replica returned %v, want ErrReplica", err)
+	}
+
+	if _, err := replica.PopItems("new", 1); !errors.Is(err, ErrReplica) {
+		t.Fatalf("pop from the replica returned %v, want ErrReplica", err)
+	}
+
+	if err := replica.Promote(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once promoted, it is writable and carries on from the leader's changelog.
+	push(t, replica, "new", "e", "five")
+	if got := drain(t, replica, "new"); len(got) != 3 || got["e"] != "five" {
+		t.Fatalf("promoted replica holds %v", got)
+	}
+
+	pos, _ := replica.ChangelogPosition()
+	if pos.ID != want.ID || pos.Seq <= want.Seq {
+		t.Fatalf("promoted replica at %+v, leader was at %+v", pos, want)
+	}
+
+	set, err := leader.Changes(context.Background(), want.Seq, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.ApplyChanges(set); !errors.Is(err, ErrNotReplica) {
+		t.Fatalf("applying changes to a promoted replica returned %v, want ErrNotReplica", err)
+	}
+}
+
+func TestReplicationGap(t *testing.T) {
+	leader := openapp(t, &Options{Appname: "orders", Rootpath: t.TempDir(), Changelog: true})
+	other := openapp(t, &Options{Appname: "orders", Rootpath: t.TempDir(), Changelog: true})
+	push(t, leader, "new", "a", "one")
+
+	// A replica never applies the changes of another changelog.
+	replica := replicate(t, other, "orders")
+	set, err := leader.Changes(context.Background(), 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := replica.ApplyChanges(set); !errors.Is(err, ErrChangelogGap) {
+		t.Fatalf("applying another changelog returned %v, want ErrChangelogGap", err)
+	}
+
+	if _, err := leader.Changes(context.Background(), 1000, 0, 0); !errors.Is(err, ErrChangelogGap) {
+		t.Fatalf("changes beyond the head returned %v, want ErrChangelogGap", err)
+	}
+}
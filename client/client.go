@@ -7,10 +7,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -23,6 +25,17 @@ type TqWorker func(*WebWorkerContext) string
 const empty = ""
 
 var notiteminqueue = errors.New("no item in queue")
+var channelpaused = errors.New("channel is paused")
+
+// ThrottledError is returned by a pop from a channel that reached its consume
+// rate limit. RetryAfter is how long until the server allows the next pop.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return "channel is throttled, retry after " + e.RetryAfter.String()
+}
 
 func serialize(data map[string]string) string {
 	databytes, err := json.Marshal(data)
@@ -59,12 +72,19 @@ func deserialize(data string) map[string]string {
 type WebClient struct {
 	url             string
 	backoffduration *time.Duration
+	popwait         time.Duration
 	token           string
 	appname         string
 }
 
 const Localhost = "http://localhost:8080/"
 
+const defaultPopWait = 30 * time.Second
+
+// ExportErrorTrailer is the HTTP trailer in which the server reports an
+// export that failed after its first items were sent.
+const ExportErrorTrailer = "X-Export-Error"
+
 type Option func(*WebClient)
 
 func WithUrl(url string) Option {
@@ -86,6 +106,14 @@ func WithBackoffDuration(duration time.Duration) Option {
 	}
 }
 
+// WithPopWait sets how long WorkerLoop parks a pop on the server waiting
+// for an item. Defaults to 30 seconds.
+func WithPopWait(wait time.Duration) Option {
+	return func(c *WebClient) {
+		c.popwait = wait
+	}
+}
+
 func WithAppname(appname string) Option {
 	return func(c *WebClient) {
 		c.appname = appname
@@ -107,6 +135,7 @@ func NewWebClient(options ...Option) *WebClient {
 		url:             "http://localhost:8080",
 		appname:         "default",
 		backoffduration: &twoseconds,
+		popwait:         defaultPopWait,
 	}
 
 	for _, option := range options {
@@ -137,9 +166,64 @@ type response struct {
 	Message string `json:"message"`
 	Error   string `json:"error"`
 	Took    string `json:"took"`
+	// RetryAfter is set on a throttled pop.
+	RetryAfter string `json:"retry_after,omitempty"`
+	// raw is the undecoded body, for endpoints that respond with a JSON document.
+	raw []byte
 }
 
 func (c *WebClient) simpleget(remote string) (*response, error) {
+	return c.simplerequest("GET", remote, nil)
+}
+
+// simplepost sends payload as a JSON body.
+func (c *WebClient) simplepost(remote string, payload any) (*response, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.simplerequest("POST", remote, bytes.NewReader(encoded))
+}
+
+func (c *WebClient) simplerequest(method, remote string, reqbody io.Reader) (*response, error) {
+	start := time.Now()
+	resp, err := c.do(method, remote, reqbody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Println("readall", err)
+		return nil, err
+	}
+
+	if bytes.HasPrefix(body, []byte("[")) {
+		return &response{Took: time.Since(start).String(), Message: string(body), raw: body}, nil
+	}
+
+	// fmt.Println("res", string(body))
+	var res response
+	err = json.Unmarshal(body, &res)
+	if err != nil {
+		fmt.Println("body", string(body), "url", remote)
+		fmt.Println("unmarshal", err)
+		return nil, err
+	}
+
+	// fmt.Println("body", string(body))
+
+	res.Took = time.Since(start).String()
+	res.raw = body
+	// fmt.Println("body", string(body))
+	return &res, nil
+}
+
+// do sends a request on behalf of the client's app and token. The caller
+// closes the response body.
+func (c *WebClient) do(method, remote string, reqbody io.Reader) (*http.Response, error) {
 	parsed, _ := url.Parse(remote)
 
 	query := parsed.Query()
@@ -155,8 +239,7 @@ func (c *WebClient) simpleget(remote string) (*response, error) {
 	remote = parsed.String()
 
 	// fmt.Println("GETing on:", url)
-	start := time.Now()
-	req, err := http.NewRequest("GET", remote, nil)
+	req, err := http.NewRequest(method, remote, reqbody)
 	if err != nil {
 		return nil, err
 	}
@@ -168,44 +251,88 @@ func (c *WebClient) simpleget(remote string) (*response, error) {
 		fmt.Println("do", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	return resp, nil
+}
+
+func (c *WebClient) Ack(item string) error {
+	finalurl := fmt.Sprintf("%s/tinyq/ack?item=%s", c.url, url.QueryEscape(item))
+
+	body, err := c.simpleget(finalurl)
 	if err != nil {
-		fmt.Println("readall", err)
-		return nil, err
+		return err
 	}
 
-	if bytes.HasPrefix(body, []byte("[")) {
-		return &response{Took: time.Since(start).String(), Message: string(body)}, nil
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
 	}
 
-	// fmt.Println("res", string(body))
-	var res response
-	err = json.Unmarshal(body, &res)
+	return nil
+}
+
+func (c *WebClient) Nack(item string, reason ...string) error {
+	finalurl := fmt.Sprintf("%s/tinyq/nack?item=%s", c.url, url.QueryEscape(item))
+	if len(reason) > 0 && len(reason[0]) > 0 {
+		finalurl += "&error=" + url.QueryEscape(reason[0])
+	}
+
+	body, err := c.simpleget(finalurl)
 	if err != nil {
-		fmt.Println("body", string(body), "url", remote)
-		fmt.Println("unmarshal", err)
-		return nil, err
+		return err
 	}
 
-	// fmt.Println("body", string(body))
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
 
-	res.Took = time.Since(start).String()
-	// fmt.Println("body", string(body))
-	return &res, nil
+	return nil
 }
 
-// func (c *WebClient) Ack(item string) error {
-// 	finalurl := fmt.Sprintf("%s/tinyq/ack?item=%s", c.url, item)
+// AckItem acknowledges an item returned by PopItems.
+func (c *WebClient) AckItem(item *tinyq.Item) error {
+	finalurl := fmt.Sprintf("%s/tinyq/ack?channel=%s&key=%s", c.url, url.QueryEscape(item.Channel), url.QueryEscape(item.Key))
 
-// 	_, err := c.simpleget(finalurl)
-// 	if err != nil {
-// 		return err
-// 	}
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
 
-// 	return nil
-// }
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
+	return nil
+}
+
+// NackItem returns an item returned by PopItems to its channel.
+func (c *WebClient) NackItem(item *tinyq.Item, reason ...string) error {
+	finalurl := fmt.Sprintf("%s/tinyq/nack?channel=%s&key=%s", c.url, url.QueryEscape(item.Channel), url.QueryEscape(item.Key))
+	if len(reason) > 0 && len(reason[0]) > 0 {
+		finalurl += "&error=" + url.QueryEscape(reason[0])
+	}
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
+	return nil
+}
+
+// throttled returns a *ThrottledError when a pop was refused by the rate
+// limit of its channel.
+func throttled(body *response) error {
+	if !strings.EqualFold(body.Message, "throttled") {
+		return nil
+	}
+
+	retry, _ := time.ParseDuration(body.RetryAfter)
+	return &ThrottledError{RetryAfter: retry}
+}
 
 func (c *WebClient) Get(key string) (string, error) {
 	finalurl := fmt.Sprintf("%s/tinyq/crud/get/%s", c.url, key)
@@ -250,198 +377,906 @@ func (c *WebClient) Pop(channel string) (string, error) {
 		return empty, err
 	}
 
+	if err := throttled(body); err != nil {
+		return "", err
+	}
+
 	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
 		return "", notiteminqueue
 	}
 
-	// if err = c.Ack(string(body)); err != nil {
-	// 	return empty, err
-	// }
-
 	return body.Message, nil
 }
 
-func (c *WebClient) Channels() (string, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/channels", c.url)
+// PopWait pops an item of a channel, waiting up to wait on the server for
+// one to arrive when the channel is empty. A zero wait returns right away.
+func (c *WebClient) PopWait(channel string, wait time.Duration) (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/pop?channel=%s", c.url, channel)
+	if wait > 0 {
+		finalurl += "&wait=" + wait.String()
+	}
 	body, err := c.simpleget(finalurl)
 	if err != nil {
 		return empty, err
 	}
 
-	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
-		return "", nil
+	if err := throttled(body); err != nil {
+		return "", err
 	}
 
-	return body.Message, nil
-}
+	if strings.EqualFold(body.Message, "empty") {
+		return "", notiteminqueue
+	}
 
-func (c *WebClient) LockChannel(channel string) (string, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/channels/lock?channel=%s", c.url, channel)
-	body, err := c.simpleget(finalurl)
-	if err != nil {
-		return empty, err
+	if strings.EqualFold(body.Message, "paused") {
+		return "", channelpaused
 	}
 
-	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
-		return "", nil
+	if strings.EqualFold(body.Message, "error") {
+		return "", errors.New(body.Error)
 	}
 
 	return body.Message, nil
 }
 
-func (c *WebClient) UnlockChannel(channel string) (string, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/channels/unlock?channel=%s", c.url, channel)
+// PopMany pops up to count items of a channel at once.
+func (c *WebClient) PopMany(channel string, count int) ([]string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/pop?channel=%s&count=%d", c.url, channel, count)
 	body, err := c.simpleget(finalurl)
 	if err != nil {
-		return empty, err
+		return nil, err
 	}
 
-	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
-		return "", nil
+	if err := throttled(body); err != nil {
+		return nil, err
 	}
 
-	return body.Message, nil
-}
+	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
+		return nil, notiteminqueue
+	}
 
-func (c *WebClient) ChannelLockStatus(channel string) (bool, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/channels/lockstatus?channel=%s", c.url, channel)
-	body, err := c.simpleget(finalurl)
-	if err != nil {
-		return false, err
+	// A single item is not sent as an array.
+	if !strings.HasPrefix(body.Message, "[") {
+		return []string{body.Message}, nil
 	}
 
-	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
-		return false, nil
+	var items []string
+	if err := json.Unmarshal([]byte(body.Message), &items); err != nil {
+		return nil, err
 	}
 
-	return body.Message == "locked", nil
+	return items, nil
 }
 
-func (c *WebClient) PauseChannel(channel string) (string, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/channels/pause?channel=%s", c.url, channel)
+// PopItems pops up to count items of a channel. Unlike Pop, the channel, key
+// and payload of the items may contain dots.
+func (c *WebClient) PopItems(channel string, count int) ([]*tinyq.Item, error) {
+	return c.popitems(channel, count, 0)
+}
+
+// popitems pops up to count items of a channel, waiting up to wait on the
+// server for one to arrive when the channel is empty.
+func (c *WebClient) popitems(channel string, count int, wait time.Duration) ([]*tinyq.Item, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/pop?channel=%s&count=%d&format=json", c.url, url.QueryEscape(channel), count)
+	if wait > 0 {
+		finalurl += "&wait=" + wait.String()
+	}
 	body, err := c.simpleget(finalurl)
 	if err != nil {
-		return empty, err
+		return nil, err
 	}
 
-	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
-		return "", nil
+	if err := throttled(body); err != nil {
+		return nil, err
 	}
 
-	return body.Message, nil
-}
-
-func (c *WebClient) ClearChannel(channel string) (string, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/channels/clear?channel=%s", c.url, channel)
-	body, err := c.simpleget(finalurl)
-	if err != nil {
-		return empty, err
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
 	}
 
-	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
-		return "", nil
+	if strings.EqualFold(body.Message, "paused") {
+		return nil, channelpaused
 	}
 
-	return body.Message, nil
-}
-
-func (c *WebClient) DeleteChannel(channel string) (string, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/channels/delete?channel=%s", c.url, channel)
-	body, err := c.simpleget(finalurl)
-	if err != nil {
-		return empty, err
+	var items []*tinyq.Item
+	if err := json.Unmarshal([]byte(body.Message), &items); err != nil {
+		return nil, err
 	}
 
-	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
-		return "", nil
+	if len(items) == 0 {
+		return nil, notiteminqueue
 	}
 
-	return body.Message, nil
+	return items, nil
 }
 
-func (c *WebClient) ResumeChannel(channel string) (string, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/channels/resume?channel=%s", c.url, channel)
+// Peek returns a page of a channel's items without removing them. Pass the
+// cursor of the previous page to continue after it.
+func (c *WebClient) Peek(channel, cursor string, limit int) (*tinyq.Page, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/items?channel=%s&cursor=%s&limit=%d", c.url, url.QueryEscape(channel), cursor, limit)
 	body, err := c.simpleget(finalurl)
 	if err != nil {
-		return empty, err
+		return nil, err
 	}
 
-	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
-		return "", nil
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
 	}
 
-	return body.Message, nil
+	var page tinyq.Page
+	if err := json.Unmarshal(body.raw, &page); err != nil {
+		return nil, err
+	}
+
+	return &page, nil
 }
 
-func (c *WebClient) Route(channel, id string, pairs ...any) error {
-	if len(pairs) > 0 {
-		data := serializepairs(pairs...)
-		if len(data) > 0 {
-			return c.Push(channel + "." + id + "." + data)
+// Browse iterates over all items of a channel, fetching pagesize items per
+// request. Iteration stops at the first error.
+//
+//	for item, err := range c.Browse("emails", 100) { ... }
+func (c *WebClient) Browse(channel string, pagesize int) iter.Seq2[*tinyq.Item, error] {
+	return func(yield func(*tinyq.Item, error) bool) {
+		var cursor string
+		for {
+			page, err := c.Peek(channel, cursor, pagesize)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			for _, item := range page.Items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if len(page.Cursor) == 0 {
+				return
+			}
+			cursor = page.Cursor
 		}
 	}
+}
 
-	return c.Push(channel + "." + id)
+// MoveOptions narrow down the items moved by Move. Zero values select every item.
+type MoveOptions struct {
+	// KeyPrefix selects the items whose key starts with it.
+	KeyPrefix string
+	// PayloadContains selects the items whose payload contains it.
+	PayloadContains string
+	// Limit caps the number of items moved.
+	Limit int
 }
 
-func (c *WebClient) RouteWithData(channel, id string, data string) error {
-	if len(data) > 0 {
-		return c.Push(channel + "." + id + "." + data)
+// Backup writes a consistent snapshot of the app's database to w while the
+// server keeps serving it, and returns the number of bytes written.
+func (c *WebClient) Backup(w io.Writer) (int64, error) {
+	resp, err := c.do("GET", fmt.Sprintf("%s/tinyq/admin/backup", c.url), nil)
+	if err != nil {
+		return 0, err
 	}
-	return c.Push(channel + "." + id)
-}
+	defer resp.Body.Close()
 
-func (c *WebClient) RouteItem(item string) error {
-	return c.Push(item)
-}
+	// A snapshot that can't be made is answered like any other error.
+	if resp.Header.Get("Content-Type") != "application/octet-stream" {
+		var body response
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return 0, err
+		}
+		return 0, errors.New(body.Error)
+	}
 
-func (c *WebClient) Push(item string) error {
-	finalurl := fmt.Sprintf("%s/tinyq/push?item=%s", c.url, item)
+	return io.Copy(w, resp.Body)
+}
 
-	_, err := c.simpleget(finalurl)
+// Restore replaces the app's database with a snapshot made by Backup.
+func (c *WebClient) Restore(r io.Reader) error {
+	body, err := c.simplerequest("POST", fmt.Sprintf("%s/tinyq/admin/restore", c.url), r)
 	if err != nil {
 		return err
 	}
 
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
 	return nil
 }
 
-// func (c *WebClient) Pause() error {
-// 	finalurl := fmt.Sprintf("%s/tinyq/channels/pause", c.url)
-
-// 	if _, err := c.simpleget(finalurl); err != nil {
-// 		return err
-// 	}
-
-// 	return nil
-// }
-
-// func (c *WebClient) Resume() error {
-// 	finalurl := fmt.Sprintf("%s/tinyq/channels/resume", c.url)
-
-// 	if _, err := c.simpleget(finalurl); err != nil {
-// 		return err
-// 	}
-
-// 	return nil
-// }
-
-func (c *WebClient) PauseStatus(channel string) (string, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/channels/status?channel=%s", c.url, channel)
+// Changes returns the changes of the app's changelog recorded after the
+// change numbered after, waiting up to wait for one. It returns
+// tinyq.ErrChangelogGap when the server no longer has them.
+func (c *WebClient) Changes(after uint64, limit int, wait time.Duration) (*tinyq.ChangeSet, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/replication/changes?after=%d&limit=%d&wait=%s", c.url, after, limit, wait)
 
 	body, err := c.simpleget(finalurl)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return body.Message, nil
-}
-
-func (c *WebClient) Databases() ([]string, error) {
-	finalurl := fmt.Sprintf("%s/tinyq/databases", c.url)
+	switch {
+	case strings.EqualFold(body.Message, "resync"):
+		return nil, tinyq.ErrChangelogGap
+	case strings.EqualFold(body.Message, "error"):
+		return nil, errors.New(body.Error)
+	}
 
-	body, err := c.simpleget(finalurl)
-	if err != nil {
+	var set tinyq.ChangeSet
+	if err := json.Unmarshal(body.raw, &set); err != nil {
+		return nil, err
+	}
+
+	return &set, nil
+}
+
+// Promote turns a follower server into a writable leader.
+func (c *WebClient) Promote() error {
+	body, err := c.simpleget(fmt.Sprintf("%s/tinyq/admin/promote", c.url))
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
+	return nil
+}
+
+// ReplicationStats returns the role of the server and how far behind its
+// leader each app is.
+func (c *WebClient) ReplicationStats() (*tinyq.ReplicationStats, error) {
+	body, err := c.simpleget(fmt.Sprintf("%s/tinyq/stats/replication", c.url))
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
+	}
+
+	var stats tinyq.ReplicationStats
+	if err := json.Unmarshal(body.raw, &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// Compact shrinks the file of the app on the server and returns its size in
+// bytes before and after.
+func (c *WebClient) Compact() (int64, int64, error) {
+	body, err := c.simpleget(fmt.Sprintf("%s/tinyq/admin/compact", c.url))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return 0, 0, errors.New(body.Error)
+	}
+
+	var sizes struct {
+		Before int64 `json:"before"`
+		After  int64 `json:"after"`
+	}
+
+	if err := json.Unmarshal(body.raw, &sizes); err != nil {
+		return 0, 0, err
+	}
+
+	return sizes.Before, sizes.After, nil
+}
+
+// Export writes the items of the channels, or of every channel of the app,
+// to w as JSON Lines, one tinyq.Item per line.
+func (c *WebClient) Export(w io.Writer, channels ...string) (int64, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/admin/export?channels=%s", c.url, url.QueryEscape(strings.Join(channels, ",")))
+	resp, err := c.do("GET", finalurl, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// An export that can't start is answered like any other error.
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		var body response
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return 0, err
+		}
+		return 0, errors.New(body.Error)
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return n, err
+	}
+
+	// Trailers are only there once the body was read to its end.
+	if message := resp.Trailer.Get(ExportErrorTrailer); len(message) > 0 {
+		return n, fmt.Errorf("export cut off: %s", message)
+	}
+
+	return n, nil
+}
+
+// Import pushes the items of a JSON Lines stream written by Export.
+func (c *WebClient) Import(r io.Reader, opt tinyq.ImportOptions) (*tinyq.ImportResult, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/admin/import?conflict=%s&channel=%s", c.url, opt.Conflict, url.QueryEscape(opt.Channel))
+	body, err := c.simplerequest("POST", finalurl, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
+	}
+
+	var result tinyq.ImportResult
+	if err := json.Unmarshal(body.raw, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Move atomically moves the items of channel src selected by opt to channel
+// dst and returns how many were moved.
+func (c *WebClient) Move(src, dst string, opt MoveOptions) (int, error) {
+	query := url.Values{}
+	query.Set("src", src)
+	query.Set("dst", dst)
+	if len(opt.KeyPrefix) > 0 {
+		query.Set("prefix", opt.KeyPrefix)
+	}
+
+	if len(opt.PayloadContains) > 0 {
+		query.Set("contains", opt.PayloadContains)
+	}
+
+	if opt.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opt.Limit))
+	}
+
+	finalurl := fmt.Sprintf("%s/tinyq/admin/move?%s", c.url, query.Encode())
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return 0, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return 0, errors.New(body.Error)
+	}
+
+	if strings.EqualFold(body.Message, "locked") {
+		return 0, errors.New("channel is locked")
+	}
+
+	return strconv.Atoi(body.Message)
+}
+
+func (c *WebClient) Channels() (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels", c.url)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return empty, err
+	}
+
+	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
+		return "", nil
+	}
+
+	return body.Message, nil
+}
+
+func (c *WebClient) LockChannel(channel string) (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/lock?channel=%s", c.url, channel)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return empty, err
+	}
+
+	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
+		return "", nil
+	}
+
+	return body.Message, nil
+}
+
+func (c *WebClient) UnlockChannel(channel string) (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/unlock?channel=%s", c.url, channel)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return empty, err
+	}
+
+	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
+		return "", nil
+	}
+
+	return body.Message, nil
+}
+
+func (c *WebClient) ChannelLockStatus(channel string) (bool, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/lockstatus?channel=%s", c.url, channel)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return false, err
+	}
+
+	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
+		return false, nil
+	}
+
+	return body.Message == "locked", nil
+}
+
+func (c *WebClient) PauseChannel(channel string) (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/pause?channel=%s", c.url, channel)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return empty, err
+	}
+
+	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
+		return "", nil
+	}
+
+	return body.Message, nil
+}
+
+func (c *WebClient) ClearChannel(channel string) (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/clear?channel=%s", c.url, channel)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return empty, err
+	}
+
+	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
+		return "", nil
+	}
+
+	return body.Message, nil
+}
+
+func (c *WebClient) DeleteChannel(channel string) (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/delete?channel=%s", c.url, channel)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return empty, err
+	}
+
+	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
+		return "", nil
+	}
+
+	return body.Message, nil
+}
+
+func (c *WebClient) SetChannelTTL(channel string, ttl time.Duration) error {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/ttl?channel=%s&ttl=%s", c.url, channel, ttl)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
+	return nil
+}
+
+// SetChannelConfig sets the capacity limits of a channel. Zero limits remove them.
+func (c *WebClient) SetChannelConfig(channel string, cc *tinyq.ChannelConfig) error {
+	overflow := cc.Overflow
+	if len(overflow) == 0 {
+		overflow = tinyq.OverflowReject
+	}
+
+	finalurl := fmt.Sprintf("%s/tinyq/channels/config?channel=%s&max_length=%d&max_bytes=%d&overflow=%s", c.url, channel, cc.MaxLength, cc.MaxBytes, overflow)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
+	return nil
+}
+
+func (c *WebClient) ChannelConfig(channel string) (*tinyq.ChannelConfig, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/config?channel=%s", c.url, channel)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
+	}
+
+	var cc tinyq.ChannelConfig
+	if err := json.Unmarshal(body.raw, &cc); err != nil {
+		return nil, err
+	}
+
+	return &cc, nil
+}
+
+// SetRateLimit limits the pops of a channel to rate per second with bursts of
+// up to burst pops. A zero rate removes the limit.
+func (c *WebClient) SetRateLimit(channel string, rate float64, burst int) error {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/ratelimit?channel=%s&rate=%s&burst=%d", c.url, url.QueryEscape(channel), strconv.FormatFloat(rate, 'f', -1, 64), burst)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
+	return nil
+}
+
+// Routes lists the routing rules of the app.
+func (c *WebClient) Routes() ([]*tinyq.RouteRule, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/routes", c.url)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
+	}
+
+	var rules []*tinyq.RouteRule
+	if err := json.Unmarshal(body.raw, &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// SetRoute adds a routing rule, or replaces the rule with the same ID, and
+// returns its ID. A rule without an ID gets one from the server.
+func (c *WebClient) SetRoute(rule *tinyq.RouteRule) (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/routes/set", c.url)
+	body, err := c.simplepost(finalurl, rule)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return "", errors.New(body.Error)
+	}
+
+	return body.Message, nil
+}
+
+func (c *WebClient) DeleteRoute(id string) error {
+	finalurl := fmt.Sprintf("%s/tinyq/routes/delete?id=%s", c.url, url.QueryEscape(id))
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
+	return nil
+}
+
+// TestRoute returns the channels a "channel.key.payload" item would be
+// delivered to by the routing rules, without pushing it.
+func (c *WebClient) TestRoute(item string) ([]string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/routes/test?item=%s", c.url, url.QueryEscape(item))
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
+	}
+
+	var channels []string
+	if err := json.Unmarshal(body.raw, &channels); err != nil {
+		return nil, err
+	}
+
+	return channels, nil
+}
+
+// BindTopic makes every item published to topic be copied into channel.
+func (c *WebClient) BindTopic(topic, channel string) error {
+	return c.topicscommand("bind", topic, channel)
+}
+
+func (c *WebClient) UnbindTopic(topic, channel string) error {
+	return c.topicscommand("unbind", topic, channel)
+}
+
+func (c *WebClient) topicscommand(command, topic, channel string) error {
+	finalurl := fmt.Sprintf("%s/tinyq/topics/%s?topic=%s&channel=%s", c.url, command, url.QueryEscape(topic), url.QueryEscape(channel))
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
+	return nil
+}
+
+// Topics returns every topic with the channels bound to it.
+func (c *WebClient) Topics() (map[string][]string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/topics", c.url)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
+	}
+
+	var topics map[string][]string
+	if err := json.Unmarshal(body.raw, &topics); err != nil {
+		return nil, err
+	}
+
+	return topics, nil
+}
+
+// Publish copies a "topic.key.payload" item into every channel bound to the
+// topic and returns the number of channels written to.
+func (c *WebClient) Publish(item string) (int, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/topics/publish?item=%s", c.url, url.QueryEscape(item))
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := pushresult(body); err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(body.Message)
+}
+
+// PublishItem publishes an item whose Channel names the topic.
+func (c *WebClient) PublishItem(item *tinyq.Item) (int, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/topics/publish", c.url)
+
+	body, err := c.simplepost(finalurl, item)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := pushresult(body); err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(body.Message)
+}
+
+func (c *WebClient) ResumeChannel(channel string) (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/resume?channel=%s", c.url, channel)
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return empty, err
+	}
+
+	if strings.EqualFold(body.Message, "error") || strings.EqualFold(body.Message, "empty") || strings.EqualFold(body.Message, "paused") {
+		return "", nil
+	}
+
+	return body.Message, nil
+}
+
+func (c *WebClient) Route(channel, id string, pairs ...any) error {
+	if len(pairs) > 0 {
+		data := serializepairs(pairs...)
+		if len(data) > 0 {
+			return c.Push(channel + "." + id + "." + data)
+		}
+	}
+
+	return c.Push(channel + "." + id)
+}
+
+func (c *WebClient) RouteWithData(channel, id string, data string) error {
+	if len(data) > 0 {
+		return c.Push(channel + "." + id + "." + data)
+	}
+	return c.Push(channel + "." + id)
+}
+
+func (c *WebClient) RouteAfter(channel, id string, delay time.Duration, pairs ...any) error {
+	if len(pairs) > 0 {
+		data := serializepairs(pairs...)
+		if len(data) > 0 {
+			return c.PushAfter(channel+"."+id+"."+data, delay)
+		}
+	}
+
+	return c.PushAfter(channel+"."+id, delay)
+}
+
+func (c *WebClient) RouteWithPriority(channel, id string, priority int, pairs ...any) error {
+	if len(pairs) > 0 {
+		data := serializepairs(pairs...)
+		if len(data) > 0 {
+			return c.PushWithPriority(channel+"."+id+"."+data, priority)
+		}
+	}
+
+	return c.PushWithPriority(channel+"."+id, priority)
+}
+
+func (c *WebClient) RouteItem(item string) error {
+	return c.Push(item)
+}
+
+func (c *WebClient) Push(item string) error {
+	finalurl := fmt.Sprintf("%s/tinyq/push?item=%s", c.url, item)
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	return pushresult(body)
+}
+
+// PushWithIdempotencyKey pushes an item at most once per key within the
+// server's dedup window. A retried push returns tinyq.ErrDuplicate.
+func (c *WebClient) PushWithIdempotencyKey(item, key string) error {
+	finalurl := fmt.Sprintf("%s/tinyq/push?item=%s&idempotency_key=%s", c.url, url.QueryEscape(item), url.QueryEscape(key))
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	return pushresult(body)
+}
+
+// PushMany pushes many "channel.key.payload" items in a single transaction
+// and returns the number of items enqueued.
+func (c *WebClient) PushMany(items ...string) (int, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/push/batch", c.url)
+
+	body, err := c.simplepost(finalurl, items)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := pushresult(body); err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(body.Message)
+}
+
+// PushItem pushes an item whose channel, key or payload may contain dots.
+func (c *WebClient) PushItem(item *tinyq.Item) error {
+	finalurl := fmt.Sprintf("%s/tinyq/push", c.url)
+
+	body, err := c.simplepost(finalurl, item)
+	if err != nil {
+		return err
+	}
+
+	return pushresult(body)
+}
+
+// pushresult turns the response of a push into an error.
+func pushresult(body *response) error {
+	if strings.EqualFold(body.Message, "duplicate") {
+		return tinyq.ErrDuplicate
+	}
+
+	if strings.EqualFold(body.Message, "full") {
+		return tinyq.ErrChannelFull
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return errors.New(body.Error)
+	}
+
+	return nil
+}
+
+func (c *WebClient) PushWithPriority(item string, priority int) error {
+	finalurl := fmt.Sprintf("%s/tinyq/push?item=%s&priority=%d", c.url, url.QueryEscape(item), priority)
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	return pushresult(body)
+}
+
+func (c *WebClient) PushWithTTL(item string, ttl time.Duration) error {
+	finalurl := fmt.Sprintf("%s/tinyq/push?item=%s&ttl=%s", c.url, url.QueryEscape(item), ttl)
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	return pushresult(body)
+}
+
+func (c *WebClient) PushAfter(item string, delay time.Duration) error {
+	finalurl := fmt.Sprintf("%s/tinyq/push?item=%s&delay=%s", c.url, url.QueryEscape(item), delay)
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	return pushresult(body)
+}
+
+func (c *WebClient) PushAt(item string, at time.Time) error {
+	finalurl := fmt.Sprintf("%s/tinyq/push?item=%s&at=%d", c.url, url.QueryEscape(item), at.Unix())
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return err
+	}
+
+	return pushresult(body)
+}
+
+// func (c *WebClient) Pause() error {
+// 	finalurl := fmt.Sprintf("%s/tinyq/channels/pause", c.url)
+
+// 	if _, err := c.simpleget(finalurl); err != nil {
+// 		return err
+// 	}
+
+// 	return nil
+// }
+
+// func (c *WebClient) Resume() error {
+// 	finalurl := fmt.Sprintf("%s/tinyq/channels/resume", c.url)
+
+// 	if _, err := c.simpleget(finalurl); err != nil {
+// 		return err
+// 	}
+
+// 	return nil
+// }
+
+func (c *WebClient) PauseStatus(channel string) (string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/channels/status?channel=%s", c.url, channel)
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return "", err
+	}
+
+	return body.Message, nil
+}
+
+func (c *WebClient) Databases() ([]string, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/databases", c.url)
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
 		return nil, err
 	}
 
@@ -472,6 +1307,93 @@ func (c *WebClient) Stats() ([]*tinyq.ChannelStats, error) {
 	return i, nil
 }
 
+func (c *WebClient) DeadLetters(channel string) ([]*tinyq.DeadLetter, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/dlq?channel=%s", c.url, url.QueryEscape(channel))
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
+	}
+
+	var i []*tinyq.DeadLetter
+	err = json.Unmarshal([]byte(body.Message), &i)
+	if err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+func (c *WebClient) DeadLetter(channel, key string) (*tinyq.DeadLetter, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/dlq?channel=%s&key=%s", c.url, url.QueryEscape(channel), url.QueryEscape(key))
+
+	body, err := c.simpleget(finalurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(body.Message, "error") {
+		return nil, errors.New(body.Error)
+	}
+
+	var i []*tinyq.DeadLetter
+	err = json.Unmarshal([]byte(body.Message), &i)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(i) == 0 {
+		return nil, tinyq.ErrNotFound
+	}
+
+	return i[0], nil
+}
+
+func (c *WebClient) RequeueDeadLetters(channel string, keys ...string) (int, error) {
+	return c.deadletterscommand("requeue", channel, keys...)
+}
+
+func (c *WebClient) PurgeDeadLetters(channel string, keys ...string) (int, error) {
+	return c.deadletterscommand("purge", channel, keys...)
+}
+
+func (c *WebClient) deadletterscommand(command, channel string, keys ...string) (int, error) {
+	finalurl := fmt.Sprintf("%s/tinyq/dlq/%s?channel=%s", c.url, command, url.QueryEscape(channel))
+	if len(keys) == 0 {
+		keys = []string{""}
+	}
+
+	var total int
+	for _, key := range keys {
+		remote := finalurl
+		if len(key) > 0 {
+			remote += "&key=" + url.QueryEscape(key)
+		}
+
+		body, err := c.simpleget(remote)
+		if err != nil {
+			return total, err
+		}
+
+		if strings.EqualFold(body.Message, "error") {
+			return total, errors.New(body.Error)
+		}
+
+		count, err := strconv.Atoi(body.Message)
+		if err != nil {
+			return total, err
+		}
+
+		total += count
+	}
+
+	return total, nil
+}
+
 func (c *WebClient) WorkerLoop(channel string, callback TqWorker) {
 	fmt.Println("Starting worker on " + channel)
 	ex := make(chan os.Signal, 1)
@@ -488,35 +1410,37 @@ func (c *WebClient) WorkerLoop(channel string, callback TqWorker) {
 			fmt.Println("received signal, exiting")
 			return
 		default:
-			item, err := c.Pop(channel)
-			if err != nil {
-				if err == notiteminqueue {
-					err = nil
-					time.Sleep(*c.backoffduration)
-				}
-				// fmt.Println("error popping item:", err)
+			// The server holds the request until an item arrives, so an
+			// empty channel needs no backoff.
+			items, err := c.popitems(channel, 1, c.popwait)
+			if err == notiteminqueue {
 				continue
 			}
 
-			item = strings.TrimSpace(item)
+			// A throttled channel tells how long to wait before the next pop.
+			var throttle *ThrottledError
+			if errors.As(err, &throttle) {
+				time.Sleep(throttle.RetryAfter)
+				continue
+			}
 
-			if item == empty || item == "error" || item == "empty" || item == "paused" {
-				// fmt.Println("sleep: backoff - " + channel)
+			if err != nil {
+				// fmt.Println("error popping item:", err)
 				time.Sleep(*c.backoffduration)
 				continue
 			}
 
-			_, key, data := tinyq.Splititem(item)
-
+			item := items[0]
 			ctx := WebWorkerContext{
-				Item:    item,
-				ID:      key,
+				Item:    item.String(),
+				ID:      item.Key,
 				Client:  c,
 				Channel: channel,
+				Headers: item.Headers,
 			}
 
-			if data != "" {
-				m := deserialize(data)
+			if len(item.Payload) > 0 {
+				m := deserialize(string(item.Payload))
 				if len(m) > 0 {
 					ctx.Data = m
 				}
@@ -526,18 +1450,35 @@ func (c *WebClient) WorkerLoop(channel string, callback TqWorker) {
 				defer func() {
 					if err := recover(); err != nil {
 						fmt.Println("panic recovered:", err)
+						if err := c.NackItem(item, fmt.Sprint(err)); err != nil {
+							fmt.Println("error releasing item:", err)
+						}
 					}
 				}()
 
 				next := callback(&ctx)
 				// fmt.Println("Next", next)
 				if next != "" {
-					err = c.Push(next)
-
-					if err != nil {
+					// The routed item carries the headers of this one, so its
+					// trace id and custom headers follow it.
+					routed := tinyq.ParseItem(next)
+					routed.Headers = ctx.Headers
+					err = c.PushItem(routed)
+
+					// A duplicate means the next step was already routed by an
+					// earlier delivery of the same item.
+					if err != nil && err != tinyq.ErrDuplicate {
 						fmt.Println("error processing item:", err)
+						if err := c.NackItem(item, err.Error()); err != nil {
+							fmt.Println("error releasing item:", err)
+						}
+						return
 					}
 				}
+
+				if err := c.AckItem(item); err != nil {
+					fmt.Println("error acknowledging item:", err)
+				}
 			}()
 		}
 	}
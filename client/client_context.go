@@ -12,6 +12,9 @@ type WebWorkerContext struct {
 	Data    map[string]string
 	Client  *WebClient
 	Channel string
+	// Headers are the system and custom headers of the item. They are
+	// forwarded with the item returned by RouteTo.
+	Headers map[string]string
 }
 
 func (ctx *WebWorkerContext) RouteNoOp() string {
@@ -66,6 +69,24 @@ func (ctx *WebWorkerContext) RouteTo(channel string, pairs ...any) string {
 	return fmt.Sprintf("%s.%s", channel, ctx.ID)
 }
 
+// Header returns a header of the item, such as "trace_id" or "attempts".
+func (ctx *WebWorkerContext) Header(k string) string {
+	if ctx.Headers == nil {
+		return ""
+	}
+
+	return ctx.Headers[k]
+}
+
+// SetHeader sets a custom header forwarded with the routed item.
+func (ctx *WebWorkerContext) SetHeader(k, v string) {
+	if ctx.Headers == nil {
+		ctx.Headers = make(map[string]string)
+	}
+
+	ctx.Headers[k] = v
+}
+
 func (ctx *WebWorkerContext) DataSet(k, v string) {
 	if ctx.Data == nil {
 		ctx.Data = make(map[string]string)
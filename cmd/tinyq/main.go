@@ -0,0 +1,264 @@
+// Command tinyq runs a tinyq server and administrative operations against it.
+//
+//	tinyq serve -config /etc/tinyq/tinyq.toml
+//	tinyq backup -app orders -o orders.db
+//	tinyq restore -app orders -i orders.db
+//	tinyq compact -app orders
+//	tinyq export -app orders -channels new,retry -o orders.jsonl
+//	tinyq import -app orders -conflict rename -i orders.jsonl
+//	tinyq replication -url http://follower:8080
+//	tinyq promote -url http://follower:8080
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sfi2k7/tinyq"
+	"github.com/sfi2k7/tinyq/client"
+	"github.com/sfi2k7/tinyq/server"
+)
+
+const usage = `usage: tinyq <command> [flags]
+
+commands:
+  serve        run a server configured by a TOML file
+  backup       write a snapshot of an app's database
+  restore      replace an app's database with a snapshot
+  compact      shrink an app's database file
+  export       write the items of an app's channels as JSON Lines
+  import       push the items of a JSON Lines export
+  replication  show the replication role and lag of a server
+  promote      turn a follower server into a writable leader
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "serve":
+		err = serve(os.Args[2:])
+	case "backup":
+		err = backup(os.Args[2:])
+	case "restore":
+		err = restore(os.Args[2:])
+	case "compact":
+		err = compact(os.Args[2:])
+	case "export":
+		err = export(os.Args[2:])
+	case "import":
+		err = importitems(os.Args[2:])
+	case "replication":
+		err = replication(os.Args[2:])
+	case "promote":
+		err = promote(os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "tinyq:", err)
+		os.Exit(1)
+	}
+}
+
+// connect adds the flags shared by every command and returns a function
+// making the client once the flags are parsed.
+func connect(fs *flag.FlagSet) func() *client.WebClient {
+	url := fs.String("url", "http://localhost:8080", "server url")
+	app := fs.String("app", "default", "app name")
+	token := fs.String("token", "", "app token")
+
+	return func() *client.WebClient {
+		return client.NewWebClient(client.WithUrl(*url), client.WithAppname(*app), client.WithToken(*token))
+	}
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configpath := fs.String("config", "", "configuration file, "+tinyq.ConfigFile+" in "+tinyq.ConfigPath+" when empty")
+	fs.Parse(args)
+
+	config, err := tinyq.LoadConfig(*configpath)
+	if err != nil {
+		return err
+	}
+
+	return server.NewQueueServer(server.WithConfig(config)).Start()
+}
+
+func backup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	newclient := connect(fs)
+	output := fs.String("o", "", "snapshot file, standard output when empty")
+	fs.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if len(*output) > 0 {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := newclient().Backup(w)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "backup: %d bytes\n", n)
+	return nil
+}
+
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	newclient := connect(fs)
+	input := fs.String("i", "", "snapshot file, standard input when empty")
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if len(*input) > 0 {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if err := newclient().Restore(r); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "restore: ok")
+	return nil
+}
+
+func compact(args []string) error {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	newclient := connect(fs)
+	fs.Parse(args)
+
+	before, after, err := newclient().Compact()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "compact: %d bytes before, %d bytes after\n", before, after)
+	return nil
+}
+
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	newclient := connect(fs)
+	channels := fs.String("channels", "", "comma separated channels, every channel when empty")
+	output := fs.String("o", "", "export file, standard output when empty")
+	fs.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if len(*output) > 0 {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var names []string
+	if len(*channels) > 0 {
+		names = strings.Split(*channels, ",")
+	}
+
+	n, err := newclient().Export(w, names...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "export: %d bytes\n", n)
+	return nil
+}
+
+func importitems(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	newclient := connect(fs)
+	input := fs.String("i", "", "export file, standard input when empty")
+	conflict := fs.String("conflict", "skip", "skip, overwrite or rename items whose key is queued")
+	channel := fs.String("channel", "", "import every item into this channel")
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if len(*input) > 0 {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	result, err := newclient().Import(r, tinyq.ImportOptions{Conflict: tinyq.ImportConflict(*conflict), Channel: *channel})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "import: %d imported, %d skipped, %d renamed\n", result.Imported, result.Skipped, result.Renamed)
+	return nil
+}
+
+func replication(args []string) error {
+	fs := flag.NewFlagSet("replication", flag.ExitOnError)
+	newclient := connect(fs)
+	fs.Parse(args)
+
+	stats, err := newclient().ReplicationStats()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("role:", stats.Role)
+	if len(stats.Leader) > 0 {
+		fmt.Println("leader:", stats.Leader)
+	}
+
+	apps := make([]string, 0, len(stats.Apps))
+	for app := range stats.Apps {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+
+	for _, app := range apps {
+		a := stats.Apps[app]
+		fmt.Printf("%s: applied %d, head %d, behind %d, lag %.3fs", app, a.Applied, a.Head, a.Behind, a.LagSeconds)
+		if len(a.Error) > 0 {
+			fmt.Printf(", error: %s", a.Error)
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
+
+func promote(args []string) error {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	newclient := connect(fs)
+	fs.Parse(args)
+
+	if err := newclient().Promote(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "promote: ok")
+	return nil
+}
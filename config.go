@@ -0,0 +1,323 @@
+package tinyq
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ConfigFile is the name of the configuration file looked up in ConfigPath.
+const ConfigFile = "tinyq.toml"
+
+// Config is the configuration file of a server or of apps opened by the
+// library, written in TOML:
+//
+//	port = 8080
+//	data_dir = "/var/lib/tinyq"
+//	logging = true
+//
+//	[defaults]
+//	lease = "1m"
+//	durability = "batch"
+//
+//	[apps."scratch-*"]
+//	driver = "memory"
+//
+//	[apps.orders]
+//	max_attempts = 10
+//	tokens = { billing = "s3cr3t" }
+//
+//	[apps.orders.channels.new]
+//	max_length = 10000
+//	overflow = "reject"
+//	ttl = "24h"
+//	rate = 50
+//	burst = 100
+//
+//	[replication]
+//	changelog = true
+type Config struct {
+	// Port the server listens on. Defaults to 8080.
+	Port int `toml:"port"`
+	// DataDir is the directory the files of every app are kept in. Defaults
+	// to the package Rootpath.
+	DataDir string `toml:"data_dir"`
+	// Logging turns on the request log of the server.
+	Logging bool `toml:"logging"`
+	// Defaults are the options of every app.
+	Defaults AppConfig `toml:"defaults"`
+	// Apps override the defaults for the apps they name. A name may be a
+	// glob pattern such as "scratch-*", applied before exact names.
+	Apps map[string]AppConfig `toml:"apps"`
+	// Replication makes the server a leader or a follower.
+	Replication ReplicationConfig `toml:"replication"`
+}
+
+// ReplicationConfig sets the replication role of a server.
+type ReplicationConfig struct {
+	// Changelog records the writes of every app for followers to replicate.
+	Changelog bool `toml:"changelog"`
+	// Follow is the url of the leader a follower replicates.
+	Follow string `toml:"follow"`
+}
+
+// AppConfig holds the options of an app. Zero values keep the default.
+type AppConfig struct {
+	Lease          time.Duration `toml:"lease"`
+	MaxAttempts    int           `toml:"max_attempts"`
+	PriorityAging  time.Duration `toml:"priority_aging"`
+	ExpiredChannel string        `toml:"expired_channel"`
+	DedupWindow    time.Duration `toml:"dedup_window"`
+	MaxPopCount    int           `toml:"max_pop_count"`
+	Driver         string        `toml:"driver"`
+	Durability     Durability    `toml:"durability"`
+	// Tokens maps the names of the tokens of the app to their value. Only
+	// apps named exactly can have tokens.
+	Tokens map[string]string `toml:"tokens"`
+	// Channels holds the limits of the channels of the app. Only apps named
+	// exactly can have channels.
+	Channels map[string]ChannelLimits `toml:"channels"`
+}
+
+// ChannelLimits are the limits of a channel set from a configuration file.
+// Zero values are unlimited.
+type ChannelLimits struct {
+	MaxLength int            `toml:"max_length"`
+	MaxBytes  int64          `toml:"max_bytes"`
+	Overflow  OverflowPolicy `toml:"overflow"`
+	TTL       time.Duration  `toml:"ttl"`
+	// Rate limits the pops of the channel per second, with bursts of up to
+	// Burst pops. Only servers enforce it.
+	Rate  float64 `toml:"rate"`
+	Burst int     `toml:"burst"`
+}
+
+// LoadConfig reads and validates a configuration file. An empty path reads
+// ConfigFile in ConfigPath, and returns an empty configuration when it does
+// not exist.
+func LoadConfig(configpath string) (*Config, error) {
+	var c Config
+	if len(configpath) == 0 {
+		configpath = filepath.Join(ConfigPath, ConfigFile)
+		if _, err := os.Stat(configpath); os.IsNotExist(err) {
+			return &c, nil
+		}
+	}
+
+	md, err := toml.DecodeFile(configpath, &c)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", configpath, err)
+	}
+
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("config %s: unknown key %q", configpath, undecoded[0].String())
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", configpath, err)
+	}
+
+	return &c, nil
+}
+
+func (c *Config) validate() error {
+	if c.Port < 0 || c.Port > 65535 {
+		return fmt.Errorf("port: %d is out of range", c.Port)
+	}
+
+	if follow := c.Replication.Follow; len(follow) > 0 {
+		u, err := url.Parse(follow)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("replication.follow: %q is not an http url", follow)
+		}
+	}
+
+	if err := c.Defaults.validate(); err != nil {
+		return fmt.Errorf("defaults.%w", err)
+	}
+
+	if len(c.Defaults.Tokens) > 0 || len(c.Defaults.Channels) > 0 {
+		return errors.New("defaults: tokens and channels are only allowed in apps")
+	}
+
+	for name, app := range c.Apps {
+		if err := app.validate(); err != nil {
+			return fmt.Errorf("apps.%s.%w", name, err)
+		}
+
+		if _, err := path.Match(name, ""); err != nil {
+			return fmt.Errorf("apps.%s: invalid pattern", name)
+		}
+
+		if ispattern(name) && (len(app.Tokens) > 0 || len(app.Channels) > 0) {
+			return fmt.Errorf("apps.%s: tokens and channels are only allowed in apps named exactly", name)
+		}
+	}
+
+	return nil
+}
+
+func (a *AppConfig) validate() error {
+	durations := map[string]time.Duration{
+		"lease":          a.Lease,
+		"priority_aging": a.PriorityAging,
+		"dedup_window":   a.DedupWindow,
+	}
+	for key, d := range durations {
+		if d < 0 {
+			return fmt.Errorf("%s: must not be negative", key)
+		}
+	}
+
+	if a.MaxPopCount < 0 {
+		return errors.New("max_pop_count: must not be negative")
+	}
+
+	if len(a.Driver) > 0 && !hasdriver(a.Driver) {
+		return fmt.Errorf("driver: unknown storage driver %q", a.Driver)
+	}
+
+	switch a.Durability {
+	case "", DurabilitySync, DurabilityBatch, DurabilityNone:
+	default:
+		return fmt.Errorf("durability: unknown durability %q", a.Durability)
+	}
+
+	for name, token := range a.Tokens {
+		if len(token) == 0 {
+			return fmt.Errorf("tokens.%s: empty token", name)
+		}
+	}
+
+	for channel, limits := range a.Channels {
+		cc := limits.config()
+		if err := cc.validate(); err != nil {
+			return fmt.Errorf("channels.%s: %w", channel, err)
+		}
+
+		if limits.TTL < 0 || limits.Rate < 0 || limits.Burst < 0 {
+			return fmt.Errorf("channels.%s: ttl, rate and burst must not be negative", channel)
+		}
+	}
+
+	return nil
+}
+
+func ispattern(name string) bool {
+	return strings.ContainsAny(name, `*?[\`)
+}
+
+// AppNames returns the names of the configured apps in the order they are
+// applied: patterns first, then exact names, each sorted.
+func (c *Config) AppNames() []string {
+	names := make([]string, 0, len(c.Apps))
+	for name := range c.Apps {
+		names = append(names, name)
+	}
+
+	slices.SortFunc(names, func(a, b string) int {
+		if ispattern(a) != ispattern(b) {
+			if ispattern(a) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+
+	return names
+}
+
+// Options returns the options of an app: the defaults, then every app entry
+// matching the app name in the order of AppNames.
+func (c *Config) Options(appname string) *Options {
+	opt := &Options{Appname: appname, Rootpath: c.DataDir}
+	c.Defaults.Apply(opt)
+
+	for _, name := range c.AppNames() {
+		if ok, _ := path.Match(name, appname); ok {
+			app := c.Apps[name]
+			app.Apply(opt)
+		}
+	}
+
+	return opt
+}
+
+// Apply sets the options the app configures, keeping the others.
+func (a *AppConfig) Apply(opt *Options) {
+	if a.Lease > 0 {
+		opt.Lease = a.Lease
+	}
+	if a.MaxAttempts != 0 {
+		opt.MaxAttempts = a.MaxAttempts
+	}
+	if a.PriorityAging > 0 {
+		opt.PriorityAging = a.PriorityAging
+	}
+	if len(a.ExpiredChannel) > 0 {
+		opt.ExpiredChannel = a.ExpiredChannel
+	}
+	if a.DedupWindow > 0 {
+		opt.DedupWindow = a.DedupWindow
+	}
+	if a.MaxPopCount > 0 {
+		opt.MaxPopCount = a.MaxPopCount
+	}
+	if len(a.Driver) > 0 {
+		opt.Driver = a.Driver
+	}
+	if len(a.Durability) > 0 {
+		opt.Durability = a.Durability
+	}
+}
+
+// ApplyChannels sets the capacity limits and TTL of the channels of an app
+// on its queue. Rate limits are left to the server.
+func (a *AppConfig) ApplyChannels(q TinyQ) error {
+	for channel, limits := range a.Channels {
+		if cc := limits.config(); cc.limited() {
+			if err := q.SetChannelConfig(channel, cc); err != nil {
+				return fmt.Errorf("channel %s: %w", channel, err)
+			}
+		}
+
+		if limits.TTL > 0 {
+			if err := q.SetChannelTTL(channel, limits.TTL); err != nil {
+				return fmt.Errorf("channel %s: %w", channel, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l ChannelLimits) config() *ChannelConfig {
+	return &ChannelConfig{MaxLength: l.MaxLength, MaxBytes: l.MaxBytes, Overflow: l.Overflow}
+}
+
+// OpenConfigured opens an app with the options of a configuration file and
+// applies the limits of its channels.
+func OpenConfigured(c *Config, appname string) (TinyQ, error) {
+	q := NewTinyQ(c.Options(appname))
+	if err := q.Open(); err != nil {
+		return nil, err
+	}
+
+	if app, ok := c.Apps[appname]; ok {
+		if err := app.ApplyChannels(q); err != nil {
+			q.Close()
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
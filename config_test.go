@@ -0,0 +1,123 @@
+package tinyq
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeconfig(t *testing.T, content string) string {
+	t.Helper()
+
+	configpath := filepath.Join(t.TempDir(), ConfigFile)
+	if err := os.WriteFile(configpath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return configpath
+}
+
+func TestLoadConfigSample(t *testing.T) {
+	c, err := LoadConfig(filepath.Join("server", "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 9876 || !c.Logging {
+		t.Fatalf("port %d, logging %v", c.Port, c.Logging)
+	}
+
+	if token := c.Apps["blue"].Tokens["default"]; token != "some_super_secret_token" {
+		t.Fatalf("token of app blue is %q", token)
+	}
+}
+
+func TestLoadConfigOptions(t *testing.T) {
+	c, err := LoadConfig(writeconfig(t, `
+data_dir = "/data"
+
+[defaults]
+lease = "1m"
+durability = "batch"
+
+[apps."scratch-*"]
+driver = "memory"
+lease = "10s"
+
+[apps.scratch-keep]
+driver = "bolt"
+
+[apps.orders.channels.new]
+max_length = 10000
+overflow = "reject"
+ttl = "24h"
+rate = 50
+burst = 100
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt := c.Options("scratch-keep")
+	if opt.Rootpath != "/data" || opt.Lease != 10*time.Second || opt.Driver != "bolt" || opt.Durability != DurabilityBatch {
+		t.Fatalf("options of scratch-keep: %+v", opt)
+	}
+
+	if opt := c.Options("orders"); opt.Lease != time.Minute || len(opt.Driver) != 0 {
+		t.Fatalf("options of orders: %+v", opt)
+	}
+
+	limits := c.Apps["orders"].Channels["new"]
+	if limits.MaxLength != 10000 || limits.Overflow != OverflowReject || limits.TTL != 24*time.Hour || limits.Rate != 50 {
+		t.Fatalf("limits of orders/new: %+v", limits)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"unknown key", `token = "secret"`, `unknown key "token"`},
+		{"port", `port = 70000`, "port: 70000 is out of range"},
+		{"follow", "[replication]\nfollow = \"leader:8080\"", "replication.follow"},
+		{"lease", "[defaults]\nlease = \"-1s\"", "defaults.lease: must not be negative"},
+		{"driver", "[apps.orders]\ndriver = \"sqlite\"", `apps.orders.driver: unknown storage driver "sqlite"`},
+		{"durability", "[apps.orders]\ndurability = \"often\"", `apps.orders.durability: unknown durability "often"`},
+		{"default tokens", "[defaults]\ntokens = { a = \"b\" }", "defaults: tokens and channels are only allowed in apps"},
+		{"pattern tokens", "[apps.\"a-*\"]\ntokens = { a = \"b\" }", "apps.a-*: tokens and channels are only allowed in apps named exactly"},
+		{"overflow", "[apps.orders.channels.new]\nmax_length = 1\noverflow = \"spill\"", "apps.orders.channels.new"},
+		{"rate", "[apps.orders.channels.new]\nrate = -1", "apps.orders.channels.new: ttl, rate and burst must not be negative"},
+		{"syntax", `port = `, "config"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := LoadConfig(writeconfig(t, tc.content))
+			if err == nil {
+				t.Fatal("config loaded")
+			}
+
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("error %q does not mention %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	defer func(previous string) { ConfigPath = previous }(ConfigPath)
+	ConfigPath = t.TempDir()
+
+	c, err := LoadConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 0 || len(c.Apps) != 0 {
+		t.Fatalf("config of a missing file: %+v", c)
+	}
+}
@@ -3,22 +3,24 @@ package tinyq
 import (
 	"errors"
 	"fmt"
-	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
-
-	"go.etcd.io/bbolt"
+	"time"
 )
 
 var Rootpath string
 var ConfigPath string
 
 const (
-	bucketPauseStatus = "internal:pause_status"
-	bucketStats       = "internal:stats"
+	bucketPauseStatus   = "internal:pause_status"
+	bucketChannelConfig = "internal:channel_config"
+	bucketStats         = "internal:stats"
+	bucketKV            = "internal:kv"
 )
 
+const defaultMaxPopCount = 10
+
 var defaultOptions = &Options{
 	Appname: "default",
 }
@@ -30,27 +32,136 @@ func init() {
 	} else {
 		Rootpath = "/var/lib/tinyq/"
 		ConfigPath = "/etc/tinyq/"
-
-		if err := createifnotexists(Rootpath); err != nil {
-			panic(err)
-		}
-
-		if err := createifnotexists(ConfigPath); err != nil {
-			panic(err)
-		}
 	}
 }
 
 type Options struct {
-	Appname  string
+	Appname string
+	// Rootpath is the directory the files of the app are kept in. Defaults
+	// to the package Rootpath.
 	Rootpath string
+	// Lease is how long a popped item stays in flight before it is
+	// returned to its channel unless acknowledged. Defaults to 30 seconds.
+	Lease time.Duration
+	// MaxAttempts is how many deliveries an item gets before it is moved to
+	// its dead-letter channel. Defaults to 5, a negative value disables it.
+	MaxAttempts int
+	// PriorityAging raises the priority of a waiting item by one level for
+	// every interval it has been queued, so low priorities are not starved.
+	// Zero disables aging.
+	PriorityAging time.Duration
+	// ExpiredChannel receives items whose TTL ran out before they were
+	// popped. When empty, expired items are discarded.
+	ExpiredChannel string
+	// DedupWindow rejects a push of a channel.key that was already pushed
+	// within the window with ErrDuplicate, even if the item has been popped
+	// since. Zero only deduplicates pushes with an idempotency key.
+	DedupWindow time.Duration
+	// MaxPopCount caps how many items a single pop returns. Defaults to 10.
+	MaxPopCount int
+	// Driver names the storage driver of the app, see RegisterDriver.
+	// Defaults to DriverBolt.
+	Driver string
+	// Durability trades the durability of writes for push throughput.
+	// Defaults to DurabilitySync.
+	Durability Durability
+	// Changelog records every write of the app for replicas to follow.
+	Changelog bool
+	// ChangelogSize is how many changes the changelog keeps. Defaults to
+	// 100000.
+	ChangelogSize int
+	// Replica opens the app as a read-only replica, written only by the
+	// changes it applies until it is promoted. It implies Changelog.
+	Replica bool
+}
+
+// Root returns the directory the files of the app are kept in.
+func (o *Options) Root() string {
+	if len(o.Rootpath) > 0 {
+		return o.Rootpath
+	}
+
+	return Rootpath
+}
+
+type pushOptions struct {
+	priority       int
+	ttl            time.Duration
+	idempotencyKey string
+	channels       []string
+}
+
+type PushOption func(*pushOptions)
+
+// WithPriority pushes an item with a priority between MinPriority and
+// MaxPriority. Higher priorities are popped first.
+func WithPriority(priority int) PushOption {
+	return func(o *pushOptions) {
+		o.priority = clamppriority(priority)
+	}
+}
+
+// WithTTL discards the item when it was not popped within ttl. It overrides
+// the default TTL of the channel.
+func WithTTL(ttl time.Duration) PushOption {
+	return func(o *pushOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithIdempotencyKey deduplicates the push by key instead of its channel.key,
+// so retries of the same push are rejected with ErrDuplicate.
+func WithIdempotencyKey(key string) PushOption {
+	return func(o *pushOptions) {
+		o.idempotencyKey = key
+	}
+}
+
+// WithChannels pushes a copy of the item into each of channels instead of its
+// own channel, all in one transaction. The push is deduplicated once, by the
+// item's own channel and key, and rejected as a whole with ErrDuplicate.
+func WithChannels(channels ...string) PushOption {
+	return func(o *pushOptions) {
+		o.channels = channels
+	}
+}
+
+func newPushOptions(opts []PushOption) *pushOptions {
+	o := &pushOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// targets returns the items a push writes: the item itself, or its copies
+// for WithChannels.
+func (o *pushOptions) targets(item *Item) ([]*Item, error) {
+	if len(o.channels) == 0 {
+		return []*Item{item}, nil
+	}
+
+	targets := make([]*Item, 0, len(o.channels))
+	for _, channel := range o.channels {
+		copied := *item
+		copied.Channel = channel
+		if err := copied.validate(); err != nil {
+			return nil, err
+		}
+		targets = append(targets, &copied)
+	}
+
+	return targets, nil
 }
 
 type tinyQ struct {
-	db     *bbolt.DB
-	isOpen bool
-	opt    *Options
-	lock   sync.Mutex
+	db       Store
+	isOpen   bool
+	opt      *Options
+	lock     sync.Mutex
+	done     chan struct{}
+	stopped  chan struct{}
+	notifier notifier
 }
 
 func NewTinyQ(opt *Options) TinyQ {
@@ -78,18 +189,66 @@ func (s *tinyQ) Open() error {
 	}
 
 	fmt.Println("options", s.opt)
-	var err error
-	var dbpath = filepath.Join(Rootpath, s.opt.Appname+".db")
-	s.db, err = bbolt.Open(dbpath, 0600, nil)
+	store, err := openstore(s.opt)
 	if err != nil {
 		return err
 	}
 
+	if s.opt.Changelog || s.opt.Replica {
+		cl := &changelogstore{Store: store, notifier: &s.notifier}
+		cl.replica.Store(s.opt.Replica)
+
+		// A replica takes the ID of the changelog it follows.
+		if !s.opt.Replica {
+			if err := cl.ensureid(); err != nil {
+				store.Close()
+				return err
+			}
+		}
+
+		store = cl
+	}
+
+	s.db = store
+
 	s.isOpen = true
+	done := make(chan struct{})
+	s.lock.Lock()
+	s.done = done
+	s.lock.Unlock()
+	s.stopped = make(chan struct{})
+	go s.housekeeping(done, s.stopped)
+
 	return nil
 }
 
+// closing returns a channel that is closed once the queue is closed, for
+// waits to end with it. It is read under the lock, as pops may wait while
+// another goroutine closes the queue.
+func (s *tinyQ) closing() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.done == nil {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+
+	return s.done
+}
+
 func (s *tinyQ) Close() error {
+	s.lock.Lock()
+	done := s.done
+	s.done = nil
+	s.lock.Unlock()
+
+	if done != nil {
+		close(done)
+		<-s.stopped
+	}
+
 	if s.db != nil {
 		err := s.db.Close()
 		if err != nil {
@@ -103,7 +262,11 @@ func (s *tinyQ) Close() error {
 }
 
 func (s *tinyQ) Set(b, k, v string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.db.Update(func(tx Tx) error {
+		if err := keepkv(tx, b); err != nil {
+			return err
+		}
+
 		bucket, err := tx.CreateBucketIfNotExists([]byte(b))
 		if err != nil {
 			return err
@@ -114,7 +277,7 @@ func (s *tinyQ) Set(b, k, v string) error {
 }
 
 func (s *tinyQ) Delete(b, k string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.db.Update(func(tx Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists([]byte(b))
 		if err != nil {
 			return err
@@ -126,10 +289,10 @@ func (s *tinyQ) Delete(b, k string) error {
 
 func (s *tinyQ) Get(b, k string) (string, error) {
 	var value []byte
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err := s.db.View(func(tx Tx) error {
 		bucket := tx.Bucket([]byte(b))
 		if bucket == nil {
-			return errors.New("bucket not found")
+			return ErrBucketNotFound
 		}
 
 		value = bucket.Get([]byte(k))
@@ -149,7 +312,7 @@ func (s *tinyQ) Get(b, k string) (string, error) {
 
 func (s *tinyQ) Has(b, k string) (bool, error) {
 	var exists bool
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err := s.db.View(func(tx Tx) error {
 		bucket := tx.Bucket([]byte(b))
 		if bucket == nil {
 			return nil
@@ -166,22 +329,39 @@ func (s *tinyQ) Has(b, k string) (bool, error) {
 	return exists, nil
 }
 
-func (s *tinyQ) Push(item string) error {
-	channel, key, data := Splititem(item)
+// Push enqueues a "channel.key.payload" item. See PushItem.
+func (s *tinyQ) Push(item string, opts ...PushOption) error {
+	return s.PushItem(ParseItem(item), opts...)
+}
+
+// PushItem enqueues an item. Pushing a key that is already queued replaces it.
+func (s *tinyQ) PushItem(item *Item, opts ...PushOption) error {
+	if err := item.validate(); err != nil {
+		return err
+	}
 
-	err := s.db.Update(func(tx *bbolt.Tx) error {
+	o := newPushOptions(opts)
+	id, window := s.dedupid(item.Channel, item.Key, o)
 
-		bucket, err := tx.CreateBucketIfNotExists([]byte(channel))
-		if err != nil {
-			return err
+	targets, err := o.targets(item)
+	if err != nil {
+		return err
+	}
+
+	err = s.pushwrite(func(tx Tx) error {
+		if len(id) > 0 {
+			if err := remember(tx, id, window, time.Now()); err != nil {
+				return err
+			}
 		}
 
-		var databytes = []byte("")
-		if data != "" {
-			databytes = []byte(data)
+		for _, target := range targets {
+			if err := s.push(tx, target, o); err != nil && err != errDropped {
+				return err
+			}
 		}
 
-		return bucket.Put([]byte(key), databytes)
+		return nil
 	})
 
 	if err != nil {
@@ -193,53 +373,205 @@ func (s *tinyQ) Push(item string) error {
 	return nil
 }
 
-// Pop retrieves and removes one or more items from the front of the queue for a given channel.
+// pushwrite runs the transaction of a push, grouped with concurrent pushes
+// when the app is configured for batched durability.
+func (s *tinyQ) pushwrite(fn func(Tx) error) error {
+	if s.opt.Durability == DurabilityBatch {
+		return s.migrating(s.db.Batch, fn)
+	}
+
+	return s.update(fn)
+}
+
+// push writes an item into its channel inside an existing transaction and
+// wakes up the pops waiting for it once the transaction is committed.
+func (s *tinyQ) push(tx Tx, item *Item, o *pushOptions) error {
+	c, err := openchannel(tx, item.Channel, true)
+	if err != nil {
+		return err
+	}
+
+	r := item.record()
+	r.Priority = o.priority
+
+	ttl := o.ttl
+	if ttl == 0 {
+		if ttl, err = channelttl(tx, item.Channel); err != nil {
+			return err
+		}
+	}
+
+	if ttl > 0 {
+		r.ExpiresAt = time.Now().Add(ttl)
+	}
+
+	if err := makeroom(tx, c, r); err != nil {
+		return err
+	}
+
+	s.signal(tx, item.Channel)
+	return c.put(r)
+}
+
+// PushBatch enqueues many items in a single transaction, so either all of
+// them are written or none. Items already pushed within the dedup window are
+// skipped; an idempotency key applies to the batch as a whole. It returns the
+// number of items enqueued.
+func (s *tinyQ) PushBatch(items []*Item, opts ...PushOption) (int, error) {
+	for i, item := range items {
+		if err := item.validate(); err != nil {
+			return 0, fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+
+	o := newPushOptions(opts)
+
+	var pushed int
+	err := s.pushwrite(func(tx Tx) error {
+		pushed = 0
+		now := time.Now()
+
+		if len(o.idempotencyKey) > 0 {
+			window := s.opt.DedupWindow
+			if window <= 0 {
+				window = defaultDedupWindow
+			}
+
+			if err := remember(tx, o.idempotencyKey, window, now); err != nil {
+				return err
+			}
+		}
+
+		for _, item := range items {
+			if s.opt.DedupWindow > 0 {
+				err := remember(tx, item.Channel+"."+item.Key, s.opt.DedupWindow, now)
+				if err == ErrDuplicate {
+					continue
+				}
+
+				if err != nil {
+					return err
+				}
+			}
+
+			err := s.push(tx, item, o)
+			if err == errDropped {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+			pushed++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return pushed, nil
+}
+
+func (s *tinyQ) maxPopCount() int {
+	if s.opt.MaxPopCount > 0 {
+		return s.opt.MaxPopCount
+	}
+
+	return defaultMaxPopCount
+}
+
+// Pop reserves one or more items from the front of the queue for a given
+// channel and returns them as "channel.key.payload" strings. See PopItems.
 func (s *tinyQ) Pop(channel string, count ...int) ([]string, error) {
+	popCount := 1
+	if len(count) > 0 {
+		popCount = count[0]
+	}
+
+	popped, err := s.PopItems(channel, popCount)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]string, 0, len(popped))
+	for _, item := range popped {
+		items = append(items, item.String())
+	}
+
+	return items, nil
+}
+
+// PopItems reserves up to count items from the front of the queue for a given channel.
+// Reserved items are moved to the channel's in-flight bucket and must be
+// acknowledged with Ack, otherwise they are returned to the channel once
+// their lease expires. An item whose key is still in flight from an earlier
+// pop stays queued until that delivery is settled.
+func (s *tinyQ) PopItems(channel string, count int) ([]*Item, error) {
 
 	// Determine how many items to pop, applying a sensible default and a maximum limit.
 	popCount := 1
-	if len(count) > 0 && count[0] > 0 {
-		popCount = count[0]
+	if count > 0 {
+		popCount = count
 	}
 
-	if popCount > 10 {
-		popCount = 10
+	if max := s.maxPopCount(); popCount > max {
+		popCount = max
 	}
 
 	// Pre-allocate the slice with the desired capacity for better performance.
-	items := make([]string, 0, popCount)
+	items := make([]*Item, 0, popCount)
+	now := time.Now()
+	deadline := now.Add(s.lease())
+
+	err := s.update(func(tx Tx) error {
+		items = items[:0]
 
-	err := s.db.Update(func(tx *bbolt.Tx) error {
 		// Attempt to get the bucket. If it doesn't exist, the queue is empty.
 		// There's no need to create it during a pop operation.
-		b := tx.Bucket([]byte(channel))
-		if b == nil {
+		if tx.Bucket([]byte(channel)) == nil {
 			return nil // Not an error, just an empty queue.
 		}
 
-		c := b.Cursor()
-
-		// **Corrected Loop Logic**:
-		// Initialize with c.First() before the loop.
-		// Iterate with c.Next() as the post-statement.
-		// This correctly walks through the items instead of repeatedly getting the first one.
-		for k, v := c.First(); k != nil && len(items) < popCount; k, v = c.Next() {
-			// Use strings.Builder for efficient string construction.
-			var sb strings.Builder
-			sb.WriteString(channel)
-			sb.WriteByte('.')
-			sb.Write(k)
-			if len(v) > 0 {
-				sb.WriteByte('.')
-				sb.Write(v)
+		c, err := openchannel(tx, channel, true)
+		if err != nil {
+			return err
+		}
+
+		for len(items) < popCount {
+			// Take the oldest item out of the channel.
+			r, err := c.shift(s.opt.PriorityAging, now)
+			if err != nil {
+				return err
 			}
-			items = append(items, sb.String())
 
-			// Delete the key that was just retrieved.
-			if err := b.Delete(k); err != nil {
-				// If deletion fails, abort the transaction.
-				return fmt.Errorf("failed to delete item %s: %w", string(k), err)
+			if r == nil {
+				break
 			}
+
+			// Stale items are dropped instead of being delivered.
+			if r.expired(now) {
+				if err := s.expire(tx, channel, r); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// Keep the item in flight until it is acknowledged.
+			if err := reserve(tx, channel, r, deadline); err != nil {
+				return fmt.Errorf("failed to reserve item %s: %w", r.Key, err)
+			}
+
+			d, err := recordDelivery(tx, channel, []byte(r.Key), now)
+			if err != nil {
+				return err
+			}
+
+			item := r.item(channel)
+			item.delivered(d.Attempts)
+			items = append(items, item)
 		}
 		return nil
 	})
@@ -248,15 +580,6 @@ func (s *tinyQ) Pop(channel string, count ...int) ([]string, error) {
 		return nil, err // Return any error from the database transaction.
 	}
 
-	// Update stats only if items were actually popped.
-	if len(items) > 0 {
-		// Note: If this secondary operation fails, the items have already been
-		// successfully removed from the DB. Consider logging this error
-		// instead of returning it to the caller to avoid confusion.
-
-		// s.statschannel <- "pop." + channel
-	}
-
 	return items, nil
 }
 
@@ -271,26 +594,35 @@ func (s *tinyQ) RemoveItem(item string) error {
 		key = item
 	}
 
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte(channel))
-		if bucket == nil {
+	return s.update(func(tx Tx) error {
+		if tx.Bucket([]byte(channel)) == nil {
 			return errors.New("channel not found")
 		}
 
-		return bucket.Delete([]byte(key))
+		c, err := openchannel(tx, channel, true)
+		if err != nil {
+			return err
+		}
+
+		if err := clearDelivery(tx, channel, []byte(key)); err != nil {
+			return err
+		}
+
+		_, err = c.remove(key)
+		return err
 	})
 }
 
 func (s *tinyQ) ListAllKeys(channel string) ([]string, error) {
 	var items []string
-	err := s.db.View(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte(channel))
-		if bucket == nil {
-			return nil
+	err := s.db.View(func(tx Tx) error {
+		c, err := openchannel(tx, channel, false)
+		if err != nil || c == nil {
+			return err
 		}
 
-		return bucket.ForEach(func(k, v []byte) error {
-			items = append(items, channel+"."+string(k))
+		return c.forEach(func(r *record) error {
+			items = append(items, channel+"."+r.Key)
 			return nil
 		})
 	})
@@ -301,12 +633,12 @@ func (s *tinyQ) ListAllKeys(channel string) ([]string, error) {
 func (s *tinyQ) Count(channel string) (int, error) {
 
 	var count int
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err := s.db.View(func(tx Tx) error {
 		bucket := tx.Bucket([]byte(channel))
 		if bucket == nil {
 			return nil
 		}
-		count = bucket.Stats().KeyN
+		count = bucket.KeyN()
 		return nil
 	})
 
@@ -0,0 +1,556 @@
+package tinyq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
+
+const (
+	bucketIndexPrefix   = "internal:index:"
+	bucketChannelFormat = "internal:channel_format"
+	// bucketChannelSize keeps the number of items and stored bytes of every
+	// channel, so capacity limits can be checked without a scan.
+	bucketChannelSize = "internal:channel_size"
+
+	// formatSequence stores items under bucket.NextSequence() keys with the
+	// user key kept in the channel's index bucket.
+	formatSequence = "1"
+	// formatPriority prefixes the sequence keys with the item's priority so
+	// higher priorities sort first.
+	formatPriority = "2"
+
+	MinPriority = 0
+	MaxPriority = 9
+)
+
+// record is the value stored for every item of a channel.
+type record struct {
+	Key        string            `json:"key"`
+	Payload    []byte            `json:"payload,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Priority   int               `json:"priority,omitempty"`
+	EnqueuedAt time.Time         `json:"enqueued_at"`
+	ExpiresAt  time.Time         `json:"expires_at,omitzero"`
+}
+
+func (r *record) expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// channelbucket gives access to the items of a channel, highest priority
+// first and in enqueue order within a priority.
+//
+// Channels written before items were kept in enqueue order stored the user
+// key directly as the bucket key. Such legacy channels can still be read and
+// are migrated the first time they are written to.
+type channelbucket struct {
+	tx     Tx
+	name   string
+	items  Bucket
+	index  Bucket
+	legacy bool
+	// unordered is set on channels of an older format read as they are,
+	// whose keys carry no priority.
+	unordered bool
+}
+
+func indexBucket(channel string) []byte {
+	return []byte(bucketIndexPrefix + channel)
+}
+
+func prioritykey(priority int, seq uint64) []byte {
+	key := make([]byte, 9)
+	key[0] = prioritybyte(priority)
+	binary.BigEndian.PutUint64(key[1:], seq)
+	return key
+}
+
+func prioritybyte(priority int) byte {
+	return byte(MaxPriority - priority)
+}
+
+func clamppriority(priority int) int {
+	if priority < MinPriority {
+		return MinPriority
+	}
+
+	if priority > MaxPriority {
+		return MaxPriority
+	}
+
+	return priority
+}
+
+// openchannel returns the channel's items, or nil when the channel does not
+// exist and create is false. In a writable transaction, a channel stored in
+// an older format fails with an unmigrated error, for tinyQ.update to migrate
+// it before the transaction is run again.
+func openchannel(tx Tx, channel string, create bool) (*channelbucket, error) {
+	items := tx.Bucket([]byte(channel))
+	if items == nil && !create {
+		return nil, nil
+	}
+
+	format := channelformat(tx, channel)
+	if format == formatPriority {
+		// The items set aside by an interrupted migration are written back first.
+		if tx.Writable() && tx.Bucket(migrateBucket(channel)) != nil {
+			return nil, &unmigrated{channel: channel}
+		}
+
+		return &channelbucket{tx: tx, name: channel, items: items, index: tx.Bucket(indexBucket(channel))}, nil
+	}
+
+	// Older formats can be read as they are, only the key layout differs.
+	if !tx.Writable() {
+		return &channelbucket{tx: tx, name: channel, items: items, index: tx.Bucket(indexBucket(channel)), legacy: len(format) == 0, unordered: true}, nil
+	}
+
+	if items != nil {
+		return nil, &unmigrated{channel: channel}
+	}
+
+	return createchannel(tx, channel)
+}
+
+func channelformat(tx Tx, channel string) string {
+	formats := tx.Bucket([]byte(bucketChannelFormat))
+	if formats == nil {
+		return ""
+	}
+
+	return string(formats.Get([]byte(channel)))
+}
+
+// createchannel creates an empty channel in the priority format, replacing
+// whatever is stored under its name.
+func createchannel(tx Tx, channel string) (*channelbucket, error) {
+	for _, name := range [][]byte{[]byte(channel), indexBucket(channel)} {
+		if err := tx.DeleteBucket(name); err != nil && err != ErrBucketNotFound {
+			return nil, err
+		}
+	}
+
+	items, err := tx.CreateBucket([]byte(channel))
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := tx.CreateBucket(indexBucket(channel))
+	if err != nil {
+		return nil, err
+	}
+
+	formats, err := tx.CreateBucketIfNotExists([]byte(bucketChannelFormat))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := formats.Put([]byte(channel), []byte(formatPriority)); err != nil {
+		return nil, err
+	}
+
+	if err := putsize(tx, channel, 0, 0); err != nil {
+		return nil, err
+	}
+
+	return &channelbucket{tx: tx, name: channel, items: items, index: index}, nil
+}
+
+func decoderecord(k, v []byte, legacy bool) (*record, error) {
+	if legacy {
+		return &record{Key: string(k), Payload: append([]byte(nil), v...)}, nil
+	}
+
+	var r record
+	if err := json.Unmarshal(v, &r); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+func (c *channelbucket) append(r *record) error {
+	seq, err := c.items.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	return c.place(r, seq)
+}
+
+// place writes r at the position of seq within its priority.
+func (c *channelbucket) place(r *record, seq uint64) error {
+	r.Priority = clamppriority(r.Priority)
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	key := prioritykey(r.Priority, seq)
+	if err := c.items.Put(key, encoded); err != nil {
+		return err
+	}
+
+	if err := c.grow(1, len(encoded)); err != nil {
+		return err
+	}
+
+	if !r.ExpiresAt.IsZero() {
+		if err := watchexpiry(c.tx, c.name, r); err != nil {
+			return err
+		}
+	}
+
+	return c.index.Put([]byte(r.Key), key)
+}
+
+// put adds an item to the end of its priority. Pushing a key that is already
+// queued replaces its payload and headers and keeps its position, unless the
+// priority changed.
+func (c *channelbucket) put(r *record) error {
+	if seq := c.index.Get([]byte(r.Key)); seq != nil {
+		existing, err := decoderecord(seq, c.items.Get(seq), false)
+		if err != nil {
+			return err
+		}
+
+		if r.EnqueuedAt.IsZero() {
+			r.EnqueuedAt = existing.EnqueuedAt
+		}
+
+		if clamppriority(r.Priority) != existing.Priority {
+			if _, err := c.remove(r.Key); err != nil {
+				return err
+			}
+
+			return c.append(r)
+		}
+
+		if !existing.ExpiresAt.Equal(r.ExpiresAt) {
+			if err := unwatchexpiry(c.tx, c.name, existing); err != nil {
+				return err
+			}
+		}
+
+		existing.Payload = r.Payload
+		existing.Headers = r.Headers
+		existing.ExpiresAt = r.ExpiresAt
+		encoded, err := json.Marshal(existing)
+		if err != nil {
+			return err
+		}
+
+		if !r.ExpiresAt.IsZero() {
+			if err := watchexpiry(c.tx, c.name, r); err != nil {
+				return err
+			}
+		}
+
+		if err := c.grow(0, len(encoded)-len(c.items.Get(seq))); err != nil {
+			return err
+		}
+
+		return c.items.Put(seq, encoded)
+	}
+
+	if r.EnqueuedAt.IsZero() {
+		r.EnqueuedAt = time.Now()
+	}
+
+	return c.append(r)
+}
+
+// requeue adds a record that was taken out of the channel back to the end of its priority.
+func (c *channelbucket) requeue(r *record) error {
+	return c.put(r)
+}
+
+func (c *channelbucket) get(key string) (*record, error) {
+	if c.legacy {
+		value := c.items.Get([]byte(key))
+		if value == nil {
+			return nil, nil
+		}
+		return decoderecord([]byte(key), value, true)
+	}
+
+	seq := c.index.Get([]byte(key))
+	if seq == nil {
+		return nil, nil
+	}
+
+	return decoderecord(seq, c.items.Get(seq), false)
+}
+
+// remove deletes an item by its user key and returns it, or nil when the key is not queued.
+func (c *channelbucket) remove(key string) (*record, error) {
+	seq := c.index.Get([]byte(key))
+	if seq == nil {
+		return nil, nil
+	}
+
+	value := c.items.Get(seq)
+	r, err := decoderecord(seq, value, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := unwatchexpiry(c.tx, c.name, r); err != nil {
+		return nil, err
+	}
+
+	if err := c.grow(-1, -len(value)); err != nil {
+		return nil, err
+	}
+
+	if err := c.items.Delete(seq); err != nil {
+		return nil, err
+	}
+
+	return r, c.index.Delete([]byte(key))
+}
+
+// head returns the key, value and record of the next item to deliver. With
+// aging, an item gains one priority level for every aging interval it has
+// waited. Items whose key is still in flight are passed over, as a key can
+// only have one lease at a time.
+func (c *channelbucket) head(aging time.Duration, now time.Time) ([]byte, []byte, *record, error) {
+	inflight := c.tx.Bucket(inflightBucket(c.name))
+	cursor := c.items.Cursor()
+	if aging <= 0 {
+		k, v := cursor.First()
+		return deliverable(cursor, inflight, k, v)
+	}
+
+	var bestk, bestv []byte
+	var bestr *record
+	var best int
+	for priority := MaxPriority; priority >= MinPriority; priority-- {
+		k, v := cursor.Seek([]byte{prioritybyte(priority)})
+		k, v, r, err := deliverable(cursor, inflight, k, v)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if k == nil || k[0] != prioritybyte(priority) {
+			continue
+		}
+
+		effective := priority + int(now.Sub(r.EnqueuedAt)/aging)
+		if bestk == nil || effective > best {
+			bestk, bestv, bestr, best = k, v, r, effective
+		}
+	}
+
+	return bestk, bestv, bestr, nil
+}
+
+// deliverable moves the cursor from k past the items whose key is in flight
+// and returns the first item that can be delivered.
+func deliverable(cursor Cursor, inflight Bucket, k, v []byte) ([]byte, []byte, *record, error) {
+	for ; k != nil; k, v = cursor.Next() {
+		r, err := decoderecord(k, v, false)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if inflight == nil || inflight.Get([]byte(r.Key)) == nil {
+			return k, v, r, nil
+		}
+	}
+
+	return nil, nil, nil, nil
+}
+
+// oldest returns the record that has been queued the longest, whatever its
+// priority, or nil when the channel is empty.
+func (c *channelbucket) oldest() (*record, error) {
+	cursor := c.items.Cursor()
+
+	var oldest *record
+	for priority := MaxPriority; priority >= MinPriority; priority-- {
+		k, v := cursor.Seek([]byte{prioritybyte(priority)})
+		if k == nil || k[0] != prioritybyte(priority) {
+			continue
+		}
+
+		r, err := decoderecord(k, v, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if oldest == nil || r.EnqueuedAt.Before(oldest.EnqueuedAt) {
+			oldest = r
+		}
+	}
+
+	return oldest, nil
+}
+
+// shift removes and returns the next item to deliver, or nil when the channel is empty.
+func (c *channelbucket) shift(aging time.Duration, now time.Time) (*record, error) {
+	k, v, r, err := c.head(aging, now)
+	if err != nil || k == nil {
+		return nil, err
+	}
+
+	if err := unwatchexpiry(c.tx, c.name, r); err != nil {
+		return nil, err
+	}
+
+	if err := c.grow(-1, -len(v)); err != nil {
+		return nil, err
+	}
+
+	if err := c.items.Delete(k); err != nil {
+		return nil, err
+	}
+
+	return r, c.index.Delete([]byte(r.Key))
+}
+
+func (c *channelbucket) forEach(fn func(r *record) error) error {
+	return c.items.ForEach(func(k, v []byte) error {
+		r, err := decoderecord(k, v, c.legacy)
+		if err != nil {
+			return err
+		}
+
+		return fn(r)
+	})
+}
+
+func (c *channelbucket) count() int {
+	return c.items.KeyN()
+}
+
+// priorities counts the items of the channel per priority. The priority is
+// read from the first byte of the keys, items are not decoded.
+func (c *channelbucket) priorities() map[int]int {
+	var counts = make(map[int]int)
+	if c.unordered {
+		// Priorities came with the priority format, older items have none.
+		if n := c.count(); n > 0 {
+			counts[MinPriority] = n
+		}
+		return counts
+	}
+
+	cursor := c.items.Cursor()
+	for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+		counts[MaxPriority-int(k[0])]++
+	}
+
+	return counts
+}
+
+func (c *channelbucket) clear() error {
+	if err := c.tx.DeleteBucket([]byte(c.name)); err != nil {
+		return err
+	}
+
+	if err := c.tx.DeleteBucket(indexBucket(c.name)); err != nil {
+		return err
+	}
+
+	var err error
+	if c.items, err = c.tx.CreateBucket([]byte(c.name)); err != nil {
+		return err
+	}
+
+	if c.index, err = c.tx.CreateBucket(indexBucket(c.name)); err != nil {
+		return err
+	}
+
+	return putsize(c.tx, c.name, 0, 0)
+}
+
+func putsize(tx Tx, channel string, length, size int64) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketChannelSize))
+	if err != nil {
+		return err
+	}
+
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value[:8], uint64(length))
+	binary.BigEndian.PutUint64(value[8:], uint64(size))
+	return bucket.Put([]byte(channel), value)
+}
+
+// size returns the number of items of the channel and the bytes they take.
+// Channels written before sizes were kept are counted once and kept from then on.
+func (c *channelbucket) size() (int64, int64, error) {
+	if bucket := c.tx.Bucket([]byte(bucketChannelSize)); bucket != nil {
+		if value := bucket.Get([]byte(c.name)); value != nil {
+			return int64(binary.BigEndian.Uint64(value[:8])), int64(binary.BigEndian.Uint64(value[8:])), nil
+		}
+	}
+
+	var length, size int64
+	err := c.items.ForEach(func(k, v []byte) error {
+		length++
+		size += int64(len(v))
+		return nil
+	})
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if c.tx.Writable() {
+		return length, size, putsize(c.tx, c.name, length, size)
+	}
+
+	return length, size, nil
+}
+
+// grow updates the kept size of the channel, unless it was never counted.
+func (c *channelbucket) grow(length, size int) error {
+	bucket := c.tx.Bucket([]byte(bucketChannelSize))
+	if bucket == nil {
+		return nil
+	}
+
+	value := bucket.Get([]byte(c.name))
+	if value == nil {
+		return nil
+	}
+
+	return putsize(c.tx, c.name, int64(binary.BigEndian.Uint64(value[:8]))+int64(length), int64(binary.BigEndian.Uint64(value[8:]))+int64(size))
+}
+
+// page returns up to limit records stored after the given item key, or from
+// the front of the channel when after is nil. The key of the last record is
+// returned as well while more records follow.
+func (c *channelbucket) page(after []byte, limit int) ([]*record, []byte, error) {
+	var records []*record
+	var last []byte
+
+	cursor := c.items.Cursor()
+	k, v := cursor.First()
+	if after != nil {
+		k, v = cursor.Seek(after)
+		if k != nil && bytes.Equal(k, after) {
+			k, v = cursor.Next()
+		}
+	}
+
+	for ; k != nil && len(records) < limit; k, v = cursor.Next() {
+		r, err := decoderecord(k, v, c.legacy)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		records = append(records, r)
+		last = append(last[:0], k...)
+	}
+
+	if k == nil {
+		return records, nil, nil
+	}
+
+	return records, last, nil
+}
@@ -0,0 +1,164 @@
+package tinyq
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestFIFO(t *testing.T) {
+	q := openq(t, nil)
+
+	for _, item := range []string{"jobs.zzz.1", "jobs.aaa.2", "jobs.mmm.3", "jobs.bbb.4"} {
+		if err := q.Push(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"jobs.zzz.1", "jobs.aaa.2", "jobs.mmm.3", "jobs.bbb.4"}
+	for i, item := range popped(t, q, "jobs", 4) {
+		if item != want[i] {
+			t.Fatalf("popped %q at %d, want %q", item, i, want[i])
+		}
+	}
+}
+
+func TestRemoveAndRepush(t *testing.T) {
+	q := openq(t, nil)
+	for _, item := range []string{"jobs.a.one", "jobs.b.two", "jobs.c.three", "jobs.a.changed"} {
+		if err := q.Push(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The user key still finds an item, and a duplicate key replaces it.
+	if n, _ := q.Count("jobs"); n != 3 {
+		t.Fatalf("jobs holds %d items, want 3", n)
+	}
+
+	if err := q.RemoveItem("jobs.b"); err != nil {
+		t.Fatal(err)
+	}
+
+	items := popped(t, q, "jobs", 2)
+	if items[0] != "jobs.a.changed" || items[1] != "jobs.c.three" {
+		t.Fatalf("popped %v", items)
+	}
+}
+
+func TestLegacyMigration(t *testing.T) {
+	q := openq(t, nil)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Channels used to keep their items keyed by item key, with the bare
+	// payload as value.
+	db, err := bbolt.Open(filepath.Join(Rootpath, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("jobs"))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range []string{"c", "a", "b"} {
+			if err := b.Put([]byte(key), []byte("legacy-"+key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A legacy channel can be read before any write migrates it.
+	if keys, err := q.ListAllKeys("jobs"); err != nil || len(keys) != 3 {
+		t.Fatalf("keys %v, %v", keys, err)
+	}
+
+	// The first write migrates it: legacy items keep their lexical order,
+	// ahead of the items pushed from then on.
+	if err := q.Push("jobs.0.new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.RemoveItem("jobs.b"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"jobs.a.legacy-a", "jobs.c.legacy-c", "jobs.0.new"}
+	for i, item := range popped(t, q, "jobs", 3) {
+		if item != want[i] {
+			t.Fatalf("popped %q at %d, want %q", item, i, want[i])
+		}
+	}
+}
+
+func TestLegacyMigrationBatches(t *testing.T) {
+	q := newtestq(t, &Options{MaxPopCount: 5000})
+
+	const n = 2*migrateBatchSize + 500
+	legacy := make(map[string]string, n)
+	for i := range n {
+		legacy[fmt.Sprintf("k%05d", i)] = "legacy"
+	}
+	legacychannel(t, q, "jobs", legacy)
+
+	// Stop a migration after its first batch, as if the app went down.
+	if err := q.db.Update(func(tx Tx) error {
+		_, err := migratebatch(tx, "jobs")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if left := count(t, q, "jobs"); left != n-migrateBatchSize {
+		t.Fatalf("jobs holds %d items after one batch, want %d", left, n-migrateBatchSize)
+	}
+
+	// The next write finishes the migration before it goes on.
+	push(t, q, "jobs", "new", "pushed")
+
+	if got := count(t, q, "jobs"); got != n+1 {
+		t.Fatalf("jobs holds %d items, want %d", got, n+1)
+	}
+
+	items, err := q.PopItems("jobs", n+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, item := range items[:n] {
+		if want := fmt.Sprintf("k%05d", i); item.Key != want {
+			t.Fatalf("popped %s at %d, want %s", item.Key, i, want)
+		}
+	}
+
+	if items[n].Key != "new" {
+		t.Fatalf("popped %s last, want new", items[n].Key)
+	}
+
+	err = q.db.View(func(tx Tx) error {
+		if tx.Bucket(migrateBucket("jobs")) != nil {
+			t.Fatal("items left set aside")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,362 @@
+package tinyq
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	bucketAttemptsPrefix   = "internal:attempts:"
+	bucketDeadLetterPrefix = "internal:deadletter:"
+	deadLetterSuffix       = ":dlq"
+	defaultMaxAttempts     = 5
+)
+
+// delivery tracks how often an item was handed out to a worker.
+type delivery struct {
+	Attempts         int       `json:"attempts"`
+	FirstDeliveredAt time.Time `json:"first_delivered_at"`
+	LastDeliveredAt  time.Time `json:"last_delivered_at"`
+	LastError        string    `json:"last_error,omitempty"`
+}
+
+// DeadLetter is an item that ran out of delivery attempts, together with
+// the history of its failed deliveries.
+type DeadLetter struct {
+	Channel          string    `json:"channel"`
+	Key              string    `json:"key"`
+	Data             string    `json:"data"`
+	Attempts         int       `json:"attempts"`
+	LastError        string    `json:"last_error"`
+	FirstDeliveredAt time.Time `json:"first_delivered_at"`
+	LastDeliveredAt  time.Time `json:"last_delivered_at"`
+	DeadAt           time.Time `json:"dead_at"`
+}
+
+// DeadLetterChannel returns the name of the companion channel that holds
+// the dead-lettered items of channel.
+func DeadLetterChannel(channel string) string {
+	return channel + deadLetterSuffix
+}
+
+func IsDeadLetterChannel(channel string) bool {
+	return strings.HasSuffix(channel, deadLetterSuffix)
+}
+
+func attemptsBucket(channel string) []byte {
+	return []byte(bucketAttemptsPrefix + channel)
+}
+
+func deadletterBucket(channel string) []byte {
+	return []byte(bucketDeadLetterPrefix + channel)
+}
+
+func (s *tinyQ) maxAttempts() int {
+	if s.opt.MaxAttempts == 0 {
+		return defaultMaxAttempts
+	}
+
+	return s.opt.MaxAttempts
+}
+
+func getDelivery(tx Tx, channel string, key []byte) (*delivery, error) {
+	var d delivery
+	bucket := tx.Bucket(attemptsBucket(channel))
+	if bucket == nil {
+		return &d, nil
+	}
+
+	value := bucket.Get(key)
+	if value == nil {
+		return &d, nil
+	}
+
+	if err := json.Unmarshal(value, &d); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+func putDelivery(tx Tx, channel string, key []byte, d *delivery) error {
+	bucket, err := tx.CreateBucketIfNotExists(attemptsBucket(channel))
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(key, encoded)
+}
+
+// recordDelivery counts one more delivery attempt of an item and returns the updated record.
+func recordDelivery(tx Tx, channel string, key []byte, now time.Time) (*delivery, error) {
+	d, err := getDelivery(tx, channel, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.Attempts == 0 {
+		d.FirstDeliveredAt = now
+	}
+
+	d.Attempts++
+	d.LastDeliveredAt = now
+	return d, putDelivery(tx, channel, key, d)
+}
+
+// failDelivery keeps the reason of a failed delivery and returns the updated record.
+func failDelivery(tx Tx, channel string, key []byte, reason string) (*delivery, error) {
+	d, err := getDelivery(tx, channel, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if reason == "" {
+		return d, nil
+	}
+
+	d.LastError = reason
+	return d, putDelivery(tx, channel, key, d)
+}
+
+func clearDelivery(tx Tx, channel string, key []byte) error {
+	bucket := tx.Bucket(attemptsBucket(channel))
+	if bucket == nil {
+		return nil
+	}
+
+	return bucket.Delete(key)
+}
+
+// forgetitem drops the delivery attempts of an item that left its channel
+// for good, and its history when the channel is a dead-letter channel.
+func forgetitem(tx Tx, channel, key string) error {
+	if err := clearDelivery(tx, channel, []byte(key)); err != nil {
+		return err
+	}
+
+	if !IsDeadLetterChannel(channel) {
+		return nil
+	}
+
+	meta := tx.Bucket(deadletterBucket(strings.TrimSuffix(channel, deadLetterSuffix)))
+	if meta == nil {
+		return nil
+	}
+
+	return meta.Delete([]byte(key))
+}
+
+// deadletter moves an item into the dead-letter channel of its channel, once
+// admit made room for it there.
+func deadletter(tx Tx, channel string, dlq *channelbucket, r *record, d *delivery) error {
+	if err := dlq.requeue(r); err != nil {
+		return err
+	}
+
+	key := []byte(r.Key)
+
+	meta, err := tx.CreateBucketIfNotExists(deadletterBucket(channel))
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(&DeadLetter{
+		Channel:          channel,
+		Key:              string(key),
+		Attempts:         d.Attempts,
+		LastError:        d.LastError,
+		FirstDeliveredAt: d.FirstDeliveredAt,
+		LastDeliveredAt:  d.LastDeliveredAt,
+		DeadAt:           time.Now(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := meta.Put(key, encoded); err != nil {
+		return err
+	}
+
+	if err := clearDelivery(tx, channel, key); err != nil {
+		return err
+	}
+
+	return incr(tx, bucketStats, "deadletter."+channel, 1)
+}
+
+func readDeadLetter(tx Tx, channel string, r *record) (*DeadLetter, error) {
+	var dl = DeadLetter{Channel: channel, Key: r.Key}
+	if meta := tx.Bucket(deadletterBucket(channel)); meta != nil {
+		if value := meta.Get([]byte(r.Key)); value != nil {
+			if err := json.Unmarshal(value, &dl); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	dl.Data = string(r.Payload)
+	return &dl, nil
+}
+
+// DeadLetters lists the dead-lettered items of a channel.
+func (s *tinyQ) DeadLetters(channel string) ([]*DeadLetter, error) {
+	var items []*DeadLetter
+	err := s.db.View(func(tx Tx) error {
+		dlq, err := openchannel(tx, DeadLetterChannel(channel), false)
+		if err != nil || dlq == nil {
+			return err
+		}
+
+		return dlq.forEach(func(r *record) error {
+			dl, err := readDeadLetter(tx, channel, r)
+			if err != nil {
+				return err
+			}
+
+			items = append(items, dl)
+			return nil
+		})
+	})
+
+	return items, err
+}
+
+// DeadLetter returns a single dead-lettered item of a channel.
+func (s *tinyQ) DeadLetter(channel, key string) (*DeadLetter, error) {
+	var dl *DeadLetter
+	err := s.db.View(func(tx Tx) error {
+		dlq, err := openchannel(tx, DeadLetterChannel(channel), false)
+		if err != nil {
+			return err
+		}
+
+		if dlq == nil {
+			return ErrNotFound
+		}
+
+		r, err := dlq.get(key)
+		if err != nil {
+			return err
+		}
+
+		if r == nil {
+			return ErrNotFound
+		}
+
+		dl, err = readDeadLetter(tx, channel, r)
+		return err
+	})
+
+	return dl, err
+}
+
+// forEachDeadLetter removes the given keys from the dead-letter channel, or
+// all of them when no keys are given, together with their metadata and
+// calls fn for every removed item.
+func forEachDeadLetter(tx Tx, channel string, keys []string, fn func(r *record) error) (int, error) {
+	if tx.Bucket([]byte(DeadLetterChannel(channel))) == nil {
+		return 0, nil
+	}
+
+	dlq, err := openchannel(tx, DeadLetterChannel(channel), true)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(keys) == 0 {
+		err := dlq.forEach(func(r *record) error {
+			keys = append(keys, r.Key)
+			return nil
+		})
+
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	meta := tx.Bucket(deadletterBucket(channel))
+
+	var count int
+	for _, key := range keys {
+		r, err := dlq.remove(key)
+		if err != nil {
+			return count, err
+		}
+
+		if r == nil {
+			continue
+		}
+
+		if err := fn(r); err != nil {
+			return count, err
+		}
+
+		if meta != nil {
+			if err := meta.Delete([]byte(key)); err != nil {
+				return count, err
+			}
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+// RequeueDeadLetters moves dead-lettered items back to their channel with a
+// fresh attempt counter. Without keys the whole dead-letter channel is
+// requeued. It fails with ErrChannelFull, requeueing nothing, when the
+// channel has no room for them.
+func (s *tinyQ) RequeueDeadLetters(channel string, keys ...string) (int, error) {
+	var count int
+	err := s.update(func(tx Tx) error {
+		c, err := openchannel(tx, channel, true)
+		if err != nil {
+			return err
+		}
+
+		s.signal(tx, channel)
+		count, err = forEachDeadLetter(tx, channel, keys, func(r *record) error {
+			if err := clearDelivery(tx, channel, []byte(r.Key)); err != nil {
+				return err
+			}
+
+			if err := admit(tx, c, r); err != nil {
+				return err
+			}
+
+			return c.requeue(r)
+		})
+
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// PurgeDeadLetters drops dead-lettered items. Without keys the whole
+// dead-letter channel is purged.
+func (s *tinyQ) PurgeDeadLetters(channel string, keys ...string) (int, error) {
+	var count int
+	err := s.update(func(tx Tx) error {
+		var err error
+		count, err = forEachDeadLetter(tx, channel, keys, func(r *record) error {
+			return nil
+		})
+
+		return err
+	})
+
+	return count, err
+}
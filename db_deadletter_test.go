@@ -0,0 +1,119 @@
+package tinyq
+
+import (
+	"testing"
+	"time"
+)
+
+// fail pops the only item of channel and nacks it with reason.
+func fail(t *testing.T, q TinyQ, channel, reason string) {
+	t.Helper()
+
+	if err := q.Nack(popped(t, q, channel, 1)[0], reason); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeadLetterAfterMaxAttempts(t *testing.T) {
+	q := openq(t, &Options{MaxAttempts: 3})
+	if err := q.Push("jobs.a.one"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	fail(t, q, "jobs", "first")
+	fail(t, q, "jobs", "second")
+
+	if n, _ := q.Count("jobs"); n != 1 {
+		t.Fatalf("jobs holds %d items before the last attempt, want 1", n)
+	}
+
+	fail(t, q, "jobs", "third")
+
+	if n, _ := q.Count("jobs"); n != 0 {
+		t.Fatalf("jobs holds %d items after the last attempt, want 0", n)
+	}
+
+	if n, _ := q.Count(DeadLetterChannel("jobs")); n != 1 {
+		t.Fatalf("dead-letter channel holds %d items, want 1", n)
+	}
+
+	dl, err := q.DeadLetter("jobs", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dl.Attempts != 3 || dl.LastError != "third" || dl.Data != "one" {
+		t.Fatalf("dead letter %+v", dl)
+	}
+
+	if dl.FirstDeliveredAt.Before(start) || dl.LastDeliveredAt.Before(dl.FirstDeliveredAt) || dl.DeadAt.Before(dl.LastDeliveredAt) {
+		t.Fatalf("dead letter timestamps %+v", dl)
+	}
+
+	dls, err := q.DeadLetters("jobs")
+	if err != nil || len(dls) != 1 || dls[0].Key != "a" {
+		t.Fatalf("dead letters %v, %v", dls, err)
+	}
+
+	// A dead-lettered item is not delivered again.
+	if items, _ := q.Pop("jobs"); len(items) != 0 {
+		t.Fatalf("popped %v from jobs, want nothing", items)
+	}
+}
+
+func TestDeadLetterLeaseExpiry(t *testing.T) {
+	q := openq(t, &Options{MaxAttempts: 1, Lease: time.Millisecond})
+	if err := q.Push("jobs.a.one"); err != nil {
+		t.Fatal(err)
+	}
+	popped(t, q, "jobs", 1)
+
+	time.Sleep(5 * time.Millisecond)
+	if _, err := q.RequeueExpired(); err != nil {
+		t.Fatal(err)
+	}
+
+	dl, err := q.DeadLetter("jobs", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dl.LastError != "lease expired" {
+		t.Fatalf("last error %q, want lease expired", dl.LastError)
+	}
+}
+
+func TestRequeueDeadLetters(t *testing.T) {
+	q := openq(t, &Options{MaxAttempts: 1})
+	for _, item := range []string{"jobs.a.one", "jobs.b.two"} {
+		if err := q.Push(item); err != nil {
+			t.Fatal(err)
+		}
+		fail(t, q, "jobs", "boom")
+	}
+
+	requeued, err := q.RequeueDeadLetters("jobs", "a")
+	if err != nil || requeued != 1 {
+		t.Fatalf("requeued %d items, %v; want 1", requeued, err)
+	}
+
+	if _, err := q.DeadLetter("jobs", "a"); err != ErrNotFound {
+		t.Fatalf("requeued item still dead-lettered: %v", err)
+	}
+
+	// A requeued item starts over with a fresh attempt counter.
+	fail(t, q, "jobs", "again")
+	if dl, err := q.DeadLetter("jobs", "a"); err != nil || dl.Attempts != 1 || dl.LastError != "again" {
+		t.Fatalf("dead letter %+v, %v", dl, err)
+	}
+
+	purged, err := q.PurgeDeadLetters("jobs")
+	if err != nil || purged != 2 {
+		t.Fatalf("purged %d items, %v; want 2", purged, err)
+	}
+
+	if dls, _ := q.DeadLetters("jobs"); len(dls) != 0 {
+		t.Fatalf("%d dead letters left after purge", len(dls))
+	}
+}
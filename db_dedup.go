@@ -0,0 +1,109 @@
+package tinyq
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+const (
+	bucketDedup = "internal:dedup"
+
+	// defaultDedupWindow applies to pushes with an idempotency key when the
+	// queue has no dedup window of its own.
+	defaultDedupWindow = 10 * time.Minute
+)
+
+// ErrDuplicate is returned by a push that was already seen within the dedup window.
+var ErrDuplicate = errors.New("duplicate")
+
+// dedupid returns the id a push is deduplicated by and how long it is
+// remembered, or an empty id when the push is not deduplicated.
+func (s *tinyQ) dedupid(channel, key string, o *pushOptions) (string, time.Duration) {
+	window := s.opt.DedupWindow
+	if len(o.idempotencyKey) > 0 {
+		if window <= 0 {
+			window = defaultDedupWindow
+		}
+		return o.idempotencyKey, window
+	}
+
+	if window <= 0 {
+		return "", 0
+	}
+
+	return channel + "." + key, window
+}
+
+// remember records a push in the dedup store, or returns ErrDuplicate when
+// the same id was pushed within its window. The entry is kept whatever
+// happens to the item later on, so popped and in-flight items are covered too.
+func remember(tx Tx, id string, window time.Duration, now time.Time) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketDedup))
+	if err != nil {
+		return err
+	}
+
+	if until := bucket.Get([]byte(id)); until != nil && now.Before(dedupuntil(until)) {
+		return ErrDuplicate
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(now.Add(window).UnixNano()))
+	return bucket.Put([]byte(id), value)
+}
+
+func dedupuntil(value []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(value)))
+}
+
+// PruneDedup forgets every push whose dedup window has passed.
+func (s *tinyQ) PruneDedup() (int, error) {
+	now := time.Now()
+
+	// Collect the stale ids first, so an idle queue never pays for a write.
+	var stale [][]byte
+	err := s.db.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte(bucketDedup))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			if !now.Before(dedupuntil(v)) {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+	})
+
+	if err != nil || len(stale) == 0 {
+		return 0, err
+	}
+
+	var pruned int
+	err = s.db.Update(func(tx Tx) error {
+		pruned = 0
+		bucket := tx.Bucket([]byte(bucketDedup))
+		if bucket == nil {
+			return nil
+		}
+
+		for _, k := range stale {
+			// The id may have been pushed again since it was collected.
+			v := bucket.Get(k)
+			if v == nil || now.Before(dedupuntil(v)) {
+				continue
+			}
+
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			pruned++
+		}
+
+		return nil
+	})
+
+	return pruned, err
+}
@@ -0,0 +1,87 @@
+package tinyq
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// dedupentries returns the number of pushes remembered by the dedup store.
+func dedupentries(t *testing.T, q *tinyQ) int {
+	t.Helper()
+
+	var n int
+	err := q.db.View(func(tx Tx) error {
+		if bucket := tx.Bucket([]byte(bucketDedup)); bucket != nil {
+			n = bucket.KeyN()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestDedupWindow(t *testing.T) {
+	q := newtestq(t, &Options{DedupWindow: 20 * time.Millisecond})
+
+	push(t, q, "jobs", "a", "one")
+
+	// The window covers the item even once it was delivered.
+	if err := q.Ack(popped(t, q, "jobs", 1)[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Push("jobs.a.two"); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("push within the window returned %v, want ErrDuplicate", err)
+	}
+
+	push(t, q, "jobs", "b", "three")
+
+	time.Sleep(30 * time.Millisecond)
+	push(t, q, "jobs", "a", "four")
+
+	if got := drain(t, q, "jobs"); len(got) != 2 || got["a"] != "four" || got["b"] != "three" {
+		t.Fatalf("jobs holds %v", got)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	q := newtestq(t, nil)
+
+	push(t, q, "jobs", "a", "one", WithIdempotencyKey("order-1"))
+
+	// The key deduplicates whatever the item, and without a queue window.
+	if err := q.PushItem(&Item{Channel: "mail", Key: "b", Payload: []byte("two")}, WithIdempotencyKey("order-1")); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("retried push returned %v, want ErrDuplicate", err)
+	}
+
+	push(t, q, "jobs", "a", "three")
+
+	if n := count(t, q, "mail"); n != 0 {
+		t.Fatalf("mail holds %d items", n)
+	}
+}
+
+func TestPruneDedup(t *testing.T) {
+	q := newtestq(t, &Options{DedupWindow: 20 * time.Millisecond})
+
+	push(t, q, "jobs", "a", "one")
+	push(t, q, "jobs", "b", "two")
+
+	if n, err := q.PruneDedup(); err != nil || n != 0 {
+		t.Fatalf("pruned %d pushes within their window: %v", n, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	push(t, q, "jobs", "c", "three")
+
+	if n, err := q.PruneDedup(); err != nil || n != 2 {
+		t.Fatalf("pruned %d pushes, want 2: %v", n, err)
+	}
+
+	if n := dedupentries(t, q); n != 1 {
+		t.Fatalf("%d pushes remembered after pruning, want 1", n)
+	}
+}
@@ -0,0 +1,240 @@
+package tinyq
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Exports and imports go through a channel this many items at a time, so a
+// channel never has to fit in memory and no transaction is held for long.
+const exportBatchSize = 1000
+
+// ImportConflict decides what an import does with an item whose key is
+// already queued in its channel.
+type ImportConflict string
+
+const (
+	// ImportSkip keeps the queued item and drops the imported one.
+	ImportSkip ImportConflict = "skip"
+	// ImportOverwrite replaces the queued item with the imported one.
+	ImportOverwrite ImportConflict = "overwrite"
+	// ImportRename imports the item under a free key made of its key and a
+	// numbered suffix such as "-1".
+	ImportRename ImportConflict = "rename"
+)
+
+type ImportOptions struct {
+	// Conflict defaults to ImportSkip.
+	Conflict ImportConflict
+	// Channel imports every item into this channel instead of its own.
+	Channel string
+}
+
+// ImportResult counts every imported line in exactly one of its fields.
+type ImportResult struct {
+	// Imported items are queued under their own key.
+	Imported int `json:"imported"`
+	// Skipped items were dropped, for a conflict or a full channel.
+	Skipped int `json:"skipped"`
+	// Renamed items are queued under a free key, see ImportRename.
+	Renamed int `json:"renamed"`
+}
+
+// Export writes the queued items of the channels, or of every channel when
+// none is given, to w as JSON Lines: one Item per line, in delivery order.
+// Items in flight are not exported. It returns the number of items written.
+func (s *tinyQ) Export(w io.Writer, channels ...string) (int, error) {
+	if len(channels) == 0 {
+		var err error
+		if channels, err = s.queuechannels(); err != nil {
+			return 0, err
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+
+	var exported int
+	for _, channel := range channels {
+		var after []byte
+		for {
+			var records []*record
+			err := s.db.View(func(tx Tx) error {
+				c, err := openchannel(tx, channel, false)
+				if err != nil || c == nil {
+					return err
+				}
+
+				records, after, err = c.page(after, exportBatchSize)
+				return err
+			})
+
+			if err != nil {
+				return exported, err
+			}
+
+			for _, r := range records {
+				if err := encoder.Encode(r.item(channel)); err != nil {
+					return exported, err
+				}
+				exported++
+			}
+
+			if after == nil {
+				break
+			}
+		}
+	}
+
+	return exported, bw.Flush()
+}
+
+// queuechannels returns the channels holding items, leaving out the KV
+// buckets ListChannels also reports. Legacy channels no write migrated yet
+// have no format, so every bucket that is not a KV bucket is a channel.
+func (s *tinyQ) queuechannels() ([]string, error) {
+	var channels []string
+	err := s.db.View(func(tx Tx) error {
+		formats := tx.Bucket([]byte(bucketChannelFormat))
+		return tx.ForEach(func(name []byte, b Bucket) error {
+			channel := string(name)
+			if isInternalChannel(channel) {
+				return nil
+			}
+
+			if formats != nil && formats.Get(name) != nil || !iskv(tx, channel) {
+				channels = append(channels, channel)
+			}
+			return nil
+		})
+	})
+
+	return channels, err
+}
+
+// Import pushes the items of a JSON Lines stream written by Export. Items are
+// committed a batch at a time: when a line is invalid, the batches before it
+// stay imported and the result counts them.
+func (s *tinyQ) Import(r io.Reader, opt ImportOptions) (*ImportResult, error) {
+	result := &ImportResult{}
+	switch opt.Conflict {
+	case "":
+		opt.Conflict = ImportSkip
+	case ImportSkip, ImportOverwrite, ImportRename:
+	default:
+		return result, errors.New("invalid import conflict")
+	}
+
+	decoder := json.NewDecoder(r)
+
+	var line int
+	for {
+		var batch []*Item
+		for len(batch) < exportBatchSize {
+			var item Item
+			err := decoder.Decode(&item)
+			if err == io.EOF {
+				break
+			}
+
+			line++
+			if err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+
+			if len(opt.Channel) > 0 {
+				item.Channel = opt.Channel
+			}
+
+			if err := item.validate(); err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+
+			batch = append(batch, &item)
+		}
+
+		if len(batch) == 0 {
+			return result, nil
+		}
+
+		if err := s.importbatch(batch, opt.Conflict, result); err != nil {
+			return result, err
+		}
+	}
+}
+
+func (s *tinyQ) importbatch(items []*Item, conflict ImportConflict, result *ImportResult) error {
+	var batch ImportResult
+	err := s.update(func(tx Tx) error {
+		batch = ImportResult{}
+		for _, item := range items {
+			c, err := openchannel(tx, item.Channel, true)
+			if err != nil {
+				return err
+			}
+
+			r := item.record()
+			r.Priority = item.Priority
+			r.EnqueuedAt = item.EnqueuedAt
+			r.ExpiresAt = item.ExpiresAt
+
+			var renamed bool
+			if c.index.Get([]byte(r.Key)) != nil {
+				switch conflict {
+				case ImportSkip:
+					batch.Skipped++
+					continue
+				case ImportRename:
+					r.Key = freekey(c, r.Key)
+					renamed = true
+				}
+			}
+
+			err = makeroom(tx, c, r)
+			if err == errDropped {
+				batch.Skipped++
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if err := c.put(r); err != nil {
+				return err
+			}
+
+			s.signal(tx, item.Channel)
+			if renamed {
+				batch.Renamed++
+			} else {
+				batch.Imported++
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	result.Imported += batch.Imported
+	result.Skipped += batch.Skipped
+	result.Renamed += batch.Renamed
+	return nil
+}
+
+// freekey returns the first of key-1, key-2, ... that is not queued in c.
+func freekey(c *channelbucket, key string) string {
+	for n := 1; ; n++ {
+		candidate := key + "-" + strconv.Itoa(n)
+		if c.index.Get([]byte(candidate)) == nil {
+			return candidate
+		}
+	}
+}
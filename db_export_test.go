@@ -0,0 +1,118 @@
+package tinyq
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// legacychannel writes items the way channels were stored before they had
+// a format, keyed by item key with the bare payload as value.
+func legacychannel(t *testing.T, q *tinyQ, channel string, items map[string]string) {
+	t.Helper()
+
+	err := q.db.Update(func(tx Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(channel))
+		if err != nil {
+			return err
+		}
+
+		for key, payload := range items {
+			if err := bucket.Put([]byte(key), []byte(payload)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportLegacyChannel(t *testing.T) {
+	q := newtestq(t, nil)
+
+	legacychannel(t, q, "jobs", map[string]string{"a": "one", "b": "two"})
+	push(t, q, "mail", "m", "three")
+	if err := q.Set("settings", "color", "blue"); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Inc("counters", "runs"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	exported, err := q.Export(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported != 3 {
+		t.Fatalf("exported %d items, want 3:\n%s", exported, buf.String())
+	}
+
+	restored := newtestq(t, &Options{Appname: "restored"})
+	result, err := restored.Import(&buf, ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 3 {
+		t.Fatalf("imported %d items, want 3", result.Imported)
+	}
+
+	if got := drain(t, restored, "jobs"); len(got) != 2 || got["a"] != "one" || got["b"] != "two" {
+		t.Fatalf("jobs holds %v", got)
+	}
+
+	if n, _ := restored.Count("settings"); n != 0 {
+		t.Fatalf("settings exported as a channel with %d items", n)
+	}
+}
+
+func TestExportChannelNames(t *testing.T) {
+	q := newtestq(t, nil)
+
+	// Only buckets written by Set or Inc are key/value data, whatever their name.
+	legacychannel(t, q, "kv", map[string]string{"a": "one"})
+	legacychannel(t, q, "__jobs__", map[string]string{"b": "two"})
+
+	var buf bytes.Buffer
+	exported, err := q.Export(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exported != 2 {
+		t.Fatalf("exported %d items, want 2:\n%s", exported, buf.String())
+	}
+}
+
+func TestImportRename(t *testing.T) {
+	q := newtestq(t, nil)
+
+	push(t, q, "jobs", "a", "queued")
+	limit(t, q, "jobs", &ChannelConfig{MaxLength: 3, Overflow: OverflowDropNewest})
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, key := range []string{"b", "a", "a"} {
+		if err := encoder.Encode(&Item{Channel: "jobs", Key: key, Payload: []byte("imported")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// b is imported and the first a renamed to a-1. The second a is renamed
+	// too but finds the channel full, so it only counts as skipped.
+	result, err := q.Import(&buf, ImportOptions{Conflict: ImportRename})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Imported != 1 || result.Renamed != 1 || result.Skipped != 1 {
+		t.Fatalf("import result %+v, want 1 imported, 1 renamed, 1 skipped", *result)
+	}
+
+	got := drain(t, q, "jobs")
+	if len(got) != 3 || got["a"] != "queued" || got["a-1"] != "imported" || got["b"] != "imported" {
+		t.Fatalf("jobs holds %v", got)
+	}
+}
@@ -0,0 +1,277 @@
+package tinyq
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	bucketInflightPrefix = "internal:inflight:"
+	defaultLease         = 30 * time.Second
+)
+
+var ErrNotInFlight = errors.New("item is not in flight")
+
+// lease is the value stored for every reserved item in a channel's in-flight bucket.
+// Leases taken before the whole record was kept only have Data and EnqueuedAt.
+type lease struct {
+	Item       *record   `json:"item,omitempty"`
+	Data       string    `json:"data,omitempty"`
+	EnqueuedAt time.Time `json:"enqueued_at,omitzero"`
+	Deadline   time.Time `json:"deadline"`
+}
+
+func (l *lease) record(key []byte) *record {
+	if l.Item != nil {
+		return l.Item
+	}
+
+	return &record{Key: string(key), Payload: []byte(l.Data), EnqueuedAt: l.EnqueuedAt}
+}
+
+func inflightBucket(channel string) []byte {
+	return []byte(bucketInflightPrefix + channel)
+}
+
+func (s *tinyQ) lease() time.Duration {
+	if s.opt.Lease > 0 {
+		return s.opt.Lease
+	}
+
+	return defaultLease
+}
+
+func reserve(tx Tx, channel string, r *record, deadline time.Time) error {
+	bucket, err := tx.CreateBucketIfNotExists(inflightBucket(channel))
+	if err != nil {
+		return err
+	}
+
+	// The TTL only applies until the item is popped, a requeued item keeps it no longer.
+	kept := *r
+	kept.ExpiresAt = time.Time{}
+
+	encoded, err := json.Marshal(&lease{Item: &kept, Deadline: deadline})
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(r.Key), encoded)
+}
+
+// release takes an item out of flight and either returns it to the end of its
+// channel or, once it ran out of delivery attempts, moves it to the dead-letter
+// channel. When that channel is full, it returns ErrChannelFull and the item
+// stays in flight.
+func (s *tinyQ) release(tx Tx, channel string, key []byte, l *lease, reason string) error {
+	inflight := tx.Bucket(inflightBucket(channel))
+	if inflight == nil {
+		return ErrNotInFlight
+	}
+
+	d, err := getDelivery(tx, channel, key)
+	if err != nil {
+		return err
+	}
+
+	target := channel
+	dead := s.maxAttempts() > 0 && d.Attempts >= s.maxAttempts() && !IsDeadLetterChannel(channel)
+	if dead {
+		target = DeadLetterChannel(channel)
+	}
+
+	c, err := openchannel(tx, target, true)
+	if err != nil {
+		return err
+	}
+
+	r := l.record(key)
+	if err := admit(tx, c, r); err != nil {
+		return err
+	}
+
+	if err := inflight.Delete(key); err != nil {
+		return err
+	}
+
+	if d, err = failDelivery(tx, channel, key, reason); err != nil {
+		return err
+	}
+
+	if dead {
+		s.unblock(tx, channel, key)
+		return deadletter(tx, channel, c, r, d)
+	}
+
+	s.signal(tx, channel)
+	return c.requeue(r)
+}
+
+// unblock wakes the pops waiting on a channel when a copy of key that was
+// held back while key was in flight is queued.
+func (s *tinyQ) unblock(tx Tx, channel string, key []byte) {
+	if index := tx.Bucket(indexBucket(channel)); index != nil && index.Get(key) != nil {
+		s.signal(tx, channel)
+	}
+}
+
+func getlease(tx Tx, channel, key string) (*lease, error) {
+	inflight := tx.Bucket(inflightBucket(channel))
+	if inflight == nil {
+		return nil, ErrNotInFlight
+	}
+
+	value := inflight.Get([]byte(key))
+	if value == nil {
+		return nil, ErrNotInFlight
+	}
+
+	var l lease
+	if err := json.Unmarshal(value, &l); err != nil {
+		return nil, err
+	}
+
+	return &l, nil
+}
+
+// Ack confirms that a popped "channel.key.payload" item was processed and
+// removes it for good.
+func (s *tinyQ) Ack(item string) error {
+	return s.AckItem(ParseItem(item))
+}
+
+// AckItem confirms that a popped item was processed and removes it for good.
+func (s *tinyQ) AckItem(item *Item) error {
+	if err := item.validate(); err != nil {
+		return err
+	}
+
+	channel, key := item.Channel, item.Key
+	return s.db.Update(func(tx Tx) error {
+		if _, err := getlease(tx, channel, key); err != nil {
+			return err
+		}
+
+		if err := clearDelivery(tx, channel, []byte(key)); err != nil {
+			return err
+		}
+
+		s.unblock(tx, channel, []byte(key))
+		return tx.Bucket(inflightBucket(channel)).Delete([]byte(key))
+	})
+}
+
+// Nack returns a popped "channel.key.payload" item to its channel. See NackItem.
+func (s *tinyQ) Nack(item string, reason ...string) error {
+	return s.NackItem(ParseItem(item), reason...)
+}
+
+// NackItem returns a popped item to its channel so it can be delivered again.
+// The optional reason is kept as the item's last error. It fails with
+// ErrChannelFull, keeping the item in flight, when the channel is full.
+func (s *tinyQ) NackItem(item *Item, reason ...string) error {
+	if err := item.validate(); err != nil {
+		return err
+	}
+
+	channel, key := item.Channel, item.Key
+	return s.update(func(tx Tx) error {
+		l, err := getlease(tx, channel, key)
+		if err != nil {
+			return err
+		}
+
+		var lasterror string
+		if len(reason) > 0 {
+			lasterror = reason[0]
+		}
+
+		return s.release(tx, channel, []byte(key), l, lasterror)
+	})
+}
+
+// RequeueExpired returns every in-flight item whose lease has expired to its channel.
+func (s *tinyQ) RequeueExpired() (int, error) {
+	now := time.Now()
+
+	// Look for expired leases in a read transaction first, so an idle queue
+	// never pays for a write.
+	var expired = make(map[string][]string)
+	err := s.db.View(func(tx Tx) error {
+		return tx.ForEach(func(name []byte, b Bucket) error {
+			if !strings.HasPrefix(string(name), bucketInflightPrefix) {
+				return nil
+			}
+
+			channel := strings.TrimPrefix(string(name), bucketInflightPrefix)
+			return b.ForEach(func(k, v []byte) error {
+				var l lease
+				if err := json.Unmarshal(v, &l); err != nil {
+					return err
+				}
+
+				if now.After(l.Deadline) {
+					expired[channel] = append(expired[channel], string(k))
+				}
+				return nil
+			})
+		})
+	})
+
+	if err != nil || len(expired) == 0 {
+		return 0, err
+	}
+
+	var requeued int
+	err = s.update(func(tx Tx) error {
+		requeued = 0
+		for channel, keys := range expired {
+			for _, key := range keys {
+				l, err := getlease(tx, channel, key)
+				if err == ErrNotInFlight {
+					continue // acknowledged in the meantime
+				}
+
+				if err != nil {
+					return err
+				}
+
+				if !now.After(l.Deadline) {
+					continue
+				}
+
+				err = s.release(tx, channel, []byte(key), l, "lease expired")
+				if err == ErrChannelFull {
+					continue // stays in flight until there is room
+				}
+
+				if err != nil {
+					return err
+				}
+				requeued++
+			}
+		}
+
+		return nil
+	})
+
+	return requeued, err
+}
+
+// InFlight returns the number of popped items of a channel that are not acknowledged yet.
+func (s *tinyQ) InFlight(channel string) (int, error) {
+	var count int
+	err := s.db.View(func(tx Tx) error {
+		bucket := tx.Bucket(inflightBucket(channel))
+		if bucket == nil {
+			return nil
+		}
+
+		count = bucket.KeyN()
+		return nil
+	})
+
+	return count, err
+}
@@ -0,0 +1,145 @@
+package tinyq
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAck(t *testing.T) {
+	q := openq(t, nil)
+	if err := q.Push("jobs.a.one"); err != nil {
+		t.Fatal(err)
+	}
+
+	item := popped(t, q, "jobs", 1)[0]
+	if item != "jobs.a.one" {
+		t.Fatalf("popped %q", item)
+	}
+
+	if n, _ := q.InFlight("jobs"); n != 1 {
+		t.Fatalf("%d items in flight, want 1", n)
+	}
+
+	if n, _ := q.Count("jobs"); n != 0 {
+		t.Fatalf("jobs holds %d items while a is in flight, want 0", n)
+	}
+
+	if err := q.Ack(item); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := q.InFlight("jobs"); n != 0 {
+		t.Fatalf("%d items in flight after ack, want 0", n)
+	}
+
+	if err := q.Ack(item); !errors.Is(err, ErrNotInFlight) {
+		t.Fatalf("second ack returned %v, want ErrNotInFlight", err)
+	}
+
+	// An acknowledged item is gone for good, even once its lease is over.
+	if _, err := q.RequeueExpired(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := q.Count("jobs"); n != 0 {
+		t.Fatalf("jobs holds %d items after ack, want 0", n)
+	}
+}
+
+func TestNack(t *testing.T) {
+	q := openq(t, nil)
+	for _, item := range []string{"jobs.a.one", "jobs.b.two"} {
+		if err := q.Push(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	item := popped(t, q, "jobs", 1)[0]
+	if err := q.Nack(item); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := q.InFlight("jobs"); n != 0 {
+		t.Fatalf("%d items in flight after nack, want 0", n)
+	}
+
+	// The nacked item is delivered again, payload included.
+	items := popped(t, q, "jobs", 2)
+	if !slices.Contains(items, item) {
+		t.Fatalf("popped %v, want %q among them", items, item)
+	}
+
+	if err := q.Nack("jobs.missing"); !errors.Is(err, ErrNotInFlight) {
+		t.Fatalf("nack of an unknown item returned %v, want ErrNotInFlight", err)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	q := openq(t, &Options{Lease: 50 * time.Millisecond})
+	for _, item := range []string{"jobs.a.one", "jobs.b.two"} {
+		if err := q.Push(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items := popped(t, q, "jobs", 2)
+
+	// Leases that have not expired stay in flight.
+	if requeued, err := q.RequeueExpired(); err != nil || requeued != 0 {
+		t.Fatalf("requeued %d items, %v; want 0", requeued, err)
+	}
+
+	if err := q.Ack(items[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if requeued, err := q.RequeueExpired(); err != nil || requeued != 1 {
+		t.Fatalf("requeued %d items, %v; want 1", requeued, err)
+	}
+
+	if n, _ := q.InFlight("jobs"); n != 0 {
+		t.Fatalf("%d items in flight after requeue, want 0", n)
+	}
+
+	if item := popped(t, q, "jobs", 1)[0]; item != items[0] {
+		t.Fatalf("popped %q, want %q", item, items[0])
+	}
+}
+
+func TestRepushInFlight(t *testing.T) {
+	q := openq(t, nil)
+	if err := q.Push("jobs.a.one"); err != nil {
+		t.Fatal(err)
+	}
+
+	first := popped(t, q, "jobs", 1)[0]
+
+	for _, item := range []string{"jobs.a.two", "jobs.b.three"} {
+		if err := q.Push(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second a waits for the first to be settled, b is not held up.
+	if got := popped(t, q, "jobs", 1)[0]; got != "jobs.b.three" {
+		t.Fatalf("popped %q while a is in flight", got)
+	}
+
+	popped(t, q, "jobs", 0)
+
+	if err := q.Ack(first); err != nil {
+		t.Fatal(err)
+	}
+
+	second := popped(t, q, "jobs", 1)[0]
+	if second != "jobs.a.two" {
+		t.Fatalf("popped %q after ack", second)
+	}
+
+	if err := q.Ack(second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,191 @@
+package tinyq
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// OverflowPolicy decides what happens to a push into a channel that is full.
+type OverflowPolicy string
+
+const (
+	// OverflowReject refuses the push with ErrChannelFull.
+	OverflowReject OverflowPolicy = "reject"
+	// OverflowDropOldest drops the items that waited longest to make room.
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDropNewest silently discards the pushed item.
+	OverflowDropNewest OverflowPolicy = "drop_newest"
+)
+
+// ErrChannelFull is returned by a push into a channel that reached its capacity.
+var ErrChannelFull = errors.New("channel is full")
+
+// errDropped reports a push that was discarded by the drop_newest policy.
+var errDropped = errors.New("item dropped")
+
+// ChannelConfig holds the capacity limits of a channel. Zero values are unlimited.
+type ChannelConfig struct {
+	MaxLength int            `json:"max_length,omitempty"`
+	MaxBytes  int64          `json:"max_bytes,omitempty"`
+	Overflow  OverflowPolicy `json:"overflow,omitempty"`
+}
+
+func (cc *ChannelConfig) validate() error {
+	switch cc.Overflow {
+	case "", OverflowReject, OverflowDropOldest, OverflowDropNewest:
+	default:
+		return errors.New("invalid overflow policy")
+	}
+
+	if cc.MaxLength < 0 || cc.MaxBytes < 0 {
+		return errors.New("invalid channel limit")
+	}
+
+	return nil
+}
+
+func (cc *ChannelConfig) limited() bool {
+	return cc.MaxLength > 0 || cc.MaxBytes > 0
+}
+
+func channelconfig(tx Tx, channel string) (*ChannelConfig, error) {
+	var cc ChannelConfig
+	bucket := tx.Bucket([]byte(bucketChannelConfig))
+	if bucket == nil {
+		return &cc, nil
+	}
+
+	value := bucket.Get([]byte(channel))
+	if value == nil {
+		return &cc, nil
+	}
+
+	if err := json.Unmarshal(value, &cc); err != nil {
+		return nil, err
+	}
+
+	return &cc, nil
+}
+
+// SetChannelConfig persists the capacity limits of a channel. A nil or
+// unlimited config removes them.
+func (s *tinyQ) SetChannelConfig(channel string, cc *ChannelConfig) error {
+	if cc == nil || !cc.limited() {
+		return s.Delete(bucketChannelConfig, channel)
+	}
+
+	if err := cc.validate(); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(cc)
+	if err != nil {
+		return err
+	}
+
+	return s.Set(bucketChannelConfig, channel, string(encoded))
+}
+
+func (s *tinyQ) ChannelConfig(channel string) (*ChannelConfig, error) {
+	var cc *ChannelConfig
+	err := s.db.View(func(tx Tx) error {
+		var err error
+		cc, err = channelconfig(tx, channel)
+		return err
+	})
+
+	return cc, err
+}
+
+// makeroom applies the capacity limits of a channel before r is put into it.
+// It returns ErrChannelFull or errDropped when r must not be written.
+func makeroom(tx Tx, c *channelbucket, r *record) error {
+	return fitchannel(tx, c, r, false)
+}
+
+// admit applies the capacity limits of a channel before an item taken out of
+// another place, such as a requeued or moved item, is put into it. That item
+// must not be lost, so a full channel refuses it with ErrChannelFull even
+// when its policy drops the newest items.
+func admit(tx Tx, c *channelbucket, r *record) error {
+	return fitchannel(tx, c, r, true)
+}
+
+func fitchannel(tx Tx, c *channelbucket, r *record, keep bool) error {
+	cc, err := channelconfig(tx, c.name)
+	if err != nil || !cc.limited() {
+		return err
+	}
+
+	length, size, err := c.size()
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	// Replacing a queued item only changes the channel's bytes.
+	grow, extra := int64(1), int64(len(encoded))
+	if existing := c.index.Get([]byte(r.Key)); existing != nil {
+		grow, extra = 0, extra-int64(len(c.items.Get(existing)))
+	}
+
+	fits := func() bool {
+		return (cc.MaxLength <= 0 || length+grow <= int64(cc.MaxLength)) &&
+			(cc.MaxBytes <= 0 || size+extra <= cc.MaxBytes)
+	}
+
+	if fits() {
+		return nil
+	}
+
+	switch cc.Overflow {
+	case OverflowDropNewest:
+		if keep {
+			return ErrChannelFull
+		}
+
+		if err := incr(tx, bucketStats, "dropped."+c.name, 1); err != nil {
+			return err
+		}
+		return errDropped
+
+	case OverflowDropOldest:
+		// An item that can't fit even into an empty channel drops nothing.
+		if cc.MaxBytes > 0 && int64(len(encoded)) > cc.MaxBytes {
+			return ErrChannelFull
+		}
+
+		var dropped int
+		for !fits() {
+			oldest, err := c.oldest()
+			if err != nil {
+				return err
+			}
+
+			if oldest == nil || oldest.Key == r.Key {
+				return ErrChannelFull
+			}
+
+			if _, err := c.remove(oldest.Key); err != nil {
+				return err
+			}
+
+			if err := forgetitem(tx, c.name, oldest.Key); err != nil {
+				return err
+			}
+			dropped++
+
+			if length, size, err = c.size(); err != nil {
+				return err
+			}
+		}
+
+		return incr(tx, bucketStats, "dropped."+c.name, dropped)
+	}
+
+	return ErrChannelFull
+}
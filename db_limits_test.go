@@ -0,0 +1,166 @@
+package tinyq
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func limit(t *testing.T, q TinyQ, channel string, cc *ChannelConfig) {
+	t.Helper()
+
+	if err := q.SetChannelConfig(channel, cc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func count(t *testing.T, q TinyQ, channel string) int {
+	t.Helper()
+
+	n, err := q.Count(channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestLimitsMove(t *testing.T) {
+	for _, overflow := range []OverflowPolicy{OverflowReject, OverflowDropNewest} {
+		t.Run(string(overflow), func(t *testing.T) {
+			q := newtestq(t, nil)
+			limit(t, q, "dst", &ChannelConfig{MaxLength: 1, Overflow: overflow})
+
+			push(t, q, "src", "a", "a")
+			push(t, q, "src", "b", "b")
+			push(t, q, "src", "c", "c")
+
+			if _, err := q.MoveItems("src", "dst", nil, 0); !errors.Is(err, ErrChannelFull) {
+				t.Fatalf("move returned %v, want ErrChannelFull", err)
+			}
+
+			if n := count(t, q, "dst"); n != 0 {
+				t.Fatalf("dst holds %d items, want 0", n)
+			}
+
+			if n := count(t, q, "src"); n != 3 {
+				t.Fatalf("src holds %d items, want 3", n)
+			}
+		})
+	}
+}
+
+func TestLimitsNack(t *testing.T) {
+	q := newtestq(t, nil)
+	limit(t, q, "jobs", &ChannelConfig{MaxLength: 1, Overflow: OverflowReject})
+
+	push(t, q, "jobs", "a", "a")
+	items, err := q.PopItems("jobs", 1)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("pop returned %v, %v", items, err)
+	}
+	push(t, q, "jobs", "b", "b")
+
+	if err := q.NackItem(items[0]); !errors.Is(err, ErrChannelFull) {
+		t.Fatalf("nack returned %v, want ErrChannelFull", err)
+	}
+
+	if n, _ := q.InFlight("jobs"); n != 1 {
+		t.Fatalf("%d items in flight, want 1", n)
+	}
+
+	if n := count(t, q, "jobs"); n != 1 {
+		t.Fatalf("jobs holds %d items, want 1", n)
+	}
+}
+
+func TestLimitsLeaseExpiry(t *testing.T) {
+	q := newtestq(t, &Options{Lease: time.Millisecond})
+	limit(t, q, "jobs", &ChannelConfig{MaxLength: 1, Overflow: OverflowReject})
+
+	push(t, q, "jobs", "a", "a")
+	if _, err := q.PopItems("jobs", 1); err != nil {
+		t.Fatal(err)
+	}
+	push(t, q, "jobs", "b", "b")
+	time.Sleep(5 * time.Millisecond)
+
+	requeued, err := q.RequeueExpired()
+	if err != nil || requeued != 0 {
+		t.Fatalf("requeued %d items, %v; want 0", requeued, err)
+	}
+
+	if n, _ := q.InFlight("jobs"); n != 1 {
+		t.Fatalf("%d items in flight, want 1", n)
+	}
+
+	// Once there is room, the item is requeued.
+	drain(t, q, "jobs")
+	if requeued, err := q.RequeueExpired(); err != nil || requeued != 1 {
+		t.Fatalf("requeued %d items, %v; want 1", requeued, err)
+	}
+
+	if got := drain(t, q, "jobs"); len(got) != 1 || got["a"] != "a" {
+		t.Fatalf("jobs holds %v", got)
+	}
+}
+
+func TestLimitsRequeueDeadLetters(t *testing.T) {
+	q := newtestq(t, &Options{MaxAttempts: 1})
+
+	push(t, q, "jobs", "a", "a")
+	items, err := q.PopItems("jobs", 1)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("pop returned %v, %v", items, err)
+	}
+
+	if err := q.NackItem(items[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	limit(t, q, "jobs", &ChannelConfig{MaxLength: 1, Overflow: OverflowReject})
+	push(t, q, "jobs", "b", "b")
+
+	if _, err := q.RequeueDeadLetters("jobs"); !errors.Is(err, ErrChannelFull) {
+		t.Fatalf("requeue returned %v, want ErrChannelFull", err)
+	}
+
+	if n := count(t, q, DeadLetterChannel("jobs")); n != 1 {
+		t.Fatalf("dead-letter channel holds %d items, want 1", n)
+	}
+}
+
+func TestLimitsDropOldestForgetsItem(t *testing.T) {
+	q := newtestq(t, nil)
+
+	push(t, q, "jobs", "a", "a", WithTTL(time.Hour))
+	items, err := q.PopItems("jobs", 1)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("pop returned %v, %v", items, err)
+	}
+
+	if err := q.NackItem(items[0], "failed"); err != nil {
+		t.Fatal(err)
+	}
+
+	limit(t, q, "jobs", &ChannelConfig{MaxLength: 1, Overflow: OverflowDropOldest})
+	push(t, q, "jobs", "b", "b")
+
+	if got := drain(t, q, "jobs"); len(got) != 1 || got["b"] != "b" {
+		t.Fatalf("jobs holds %v", got)
+	}
+
+	if n := expiries(t, q); n != 0 {
+		t.Fatalf("%d expiry entries, want 0", n)
+	}
+
+	err = q.db.View(func(tx Tx) error {
+		if d, err := getDelivery(tx, "jobs", []byte("a")); err != nil || d.Attempts != 0 {
+			t.Fatalf("dropped item keeps %d attempts, %v", d.Attempts, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,183 @@
+package tinyq
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	bucketMigratePrefix = "internal:migrate:"
+
+	// migrateBatchSize is how many items a migration moves per transaction.
+	migrateBatchSize = 1000
+)
+
+// unmigrated is returned by a write transaction that met a channel stored in
+// an older format.
+type unmigrated struct {
+	channel string
+}
+
+func (e *unmigrated) Error() string {
+	return fmt.Sprintf("channel %s must be migrated", e.channel)
+}
+
+func migrateBucket(channel string) []byte {
+	return []byte(bucketMigratePrefix + channel)
+}
+
+// update runs fn in a write transaction. A channel of an older format met on
+// the way is migrated first and fn is run again.
+func (s *tinyQ) update(fn func(Tx) error) error {
+	return s.migrating(s.db.Update, fn)
+}
+
+func (s *tinyQ) migrating(run func(func(Tx) error) error, fn func(Tx) error) error {
+	for {
+		err := run(fn)
+
+		var legacy *unmigrated
+		if !errors.As(err, &legacy) {
+			return err
+		}
+
+		if err := s.migratechannel(legacy.channel); err != nil {
+			return err
+		}
+	}
+}
+
+// migratechannel rewrites a channel stored in an older format in the priority
+// format, migrateBatchSize items per transaction, so a large channel neither
+// holds the write lock nor sits in memory all at once. The items are first
+// set aside in their order, then written back once the channel is recreated.
+// Meanwhile readers see the channel without the items set aside, and a
+// migration that was interrupted is resumed by the next write.
+func (s *tinyQ) migratechannel(channel string) error {
+	for {
+		var done bool
+		err := s.db.Update(func(tx Tx) error {
+			var err error
+			done, err = migratebatch(tx, channel)
+			return err
+		})
+
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// migratebatch moves the next batch of a migration and reports whether the
+// channel is done.
+func migratebatch(tx Tx, channel string) (bool, error) {
+	if tx.Bucket([]byte(channel)) == nil {
+		// The channel was deleted while it was migrated.
+		if err := tx.DeleteBucket(migrateBucket(channel)); err != nil && err != ErrBucketNotFound {
+			return false, err
+		}
+		return true, nil
+	}
+
+	aside, err := tx.CreateBucketIfNotExists(migrateBucket(channel))
+	if err != nil {
+		return false, err
+	}
+
+	if format := channelformat(tx, channel); format != formatPriority {
+		return false, setaside(tx, channel, format, aside)
+	}
+
+	return writeback(tx, channel, aside)
+}
+
+// setaside moves a batch of items out of a channel of an older format, keyed
+// by their position. Once the channel is empty, it is recreated in the
+// priority format with the positions of the items set aside taken.
+func setaside(tx Tx, channel, format string, aside Bucket) error {
+	items := tx.Bucket([]byte(channel))
+	now := time.Now()
+
+	cursor := items.Cursor()
+	k, v := cursor.First()
+	for n := 0; k != nil && n < migrateBatchSize; n++ {
+		r, err := decoderecord(k, v, len(format) == 0)
+		if err != nil {
+			return err
+		}
+
+		if r.EnqueuedAt.IsZero() {
+			r.EnqueuedAt = now
+		}
+
+		encoded, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+
+		seq, err := aside.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		if err := aside.Put(binary.BigEndian.AppendUint64(nil, seq), encoded); err != nil {
+			return err
+		}
+
+		if err := items.Delete(k); err != nil {
+			return err
+		}
+
+		k, v = cursor.First()
+	}
+
+	if k != nil {
+		return nil
+	}
+
+	c, err := createchannel(tx, channel)
+	if err != nil {
+		return err
+	}
+
+	last, _ := aside.Cursor().Last()
+	if last == nil {
+		return nil
+	}
+
+	return c.items.SetSequence(binary.BigEndian.Uint64(last))
+}
+
+// writeback writes a batch of the items set aside back into the channel, at
+// the positions they had.
+func writeback(tx Tx, channel string, aside Bucket) (bool, error) {
+	c := &channelbucket{tx: tx, name: channel, items: tx.Bucket([]byte(channel)), index: tx.Bucket(indexBucket(channel))}
+
+	cursor := aside.Cursor()
+	k, v := cursor.First()
+	for n := 0; k != nil && n < migrateBatchSize; n++ {
+		var r record
+		if err := json.Unmarshal(v, &r); err != nil {
+			return false, err
+		}
+
+		if err := c.place(&r, binary.BigEndian.Uint64(k)); err != nil {
+			return false, err
+		}
+
+		if err := aside.Delete(k); err != nil {
+			return false, err
+		}
+
+		k, v = cursor.First()
+	}
+
+	if k != nil {
+		return false, nil
+	}
+
+	return true, tx.DeleteBucket(migrateBucket(channel))
+}
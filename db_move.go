@@ -0,0 +1,137 @@
+package tinyq
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrItemExists is returned by MoveItems when an item's key is already
+// queued in the destination channel.
+var ErrItemExists = errors.New("item already queued")
+
+// MoveFilter selects the items moved by MoveItems. A nil filter selects every item.
+type MoveFilter func(item *Item) bool
+
+// KeyPrefix selects the items whose key starts with prefix.
+func KeyPrefix(prefix string) MoveFilter {
+	return func(item *Item) bool {
+		return strings.HasPrefix(item.Key, prefix)
+	}
+}
+
+// PayloadMatch selects the items whose payload satisfies match.
+func PayloadMatch(match func(payload []byte) bool) MoveFilter {
+	return func(item *Item) bool {
+		return match(item.Payload)
+	}
+}
+
+// PayloadContains selects the items whose payload contains sub.
+func PayloadContains(sub string) MoveFilter {
+	return PayloadMatch(func(payload []byte) bool {
+		return bytes.Contains(payload, []byte(sub))
+	})
+}
+
+// MoveItems moves up to limit queued items selected by filter from src to
+// the end of dst in a single transaction, so an item is never lost or
+// duplicated midway. Moved items keep their priority, headers and payload
+// and start over with no delivery attempts. A limit of zero moves every
+// selected item. In-flight items are not moved. The move fails with
+// ErrItemExists, moving nothing, when a selected key is already queued or in
+// flight in dst, and with ErrChannelFull when dst has no room for the
+// selected items.
+func (s *tinyQ) MoveItems(src, dst string, filter MoveFilter, limit int) (int, error) {
+	if len(src) == 0 || len(dst) == 0 {
+		return 0, errors.New("channel is missing")
+	}
+
+	if src == dst {
+		return 0, errors.New("source and destination are the same channel")
+	}
+
+	var moved int
+	err := s.update(func(tx Tx) error {
+		moved = 0
+		if tx.Bucket([]byte(src)) == nil {
+			return nil
+		}
+
+		from, err := openchannel(tx, src, true)
+		if err != nil {
+			return err
+		}
+
+		// Pick the keys first, the channel can't change while it is iterated.
+		var keys []string
+		errLimit := errors.New("limit reached")
+		err = from.forEach(func(r *record) error {
+			if limit > 0 && len(keys) >= limit {
+				return errLimit
+			}
+
+			if filter == nil || filter(r.item(src)) {
+				keys = append(keys, r.Key)
+			}
+			return nil
+		})
+
+		if err != nil && err != errLimit {
+			return err
+		}
+
+		if len(keys) == 0 {
+			return nil
+		}
+
+		to, err := openchannel(tx, dst, true)
+		if err != nil {
+			return err
+		}
+
+		inflight := tx.Bucket(inflightBucket(dst))
+		for _, key := range keys {
+			if to.index.Get([]byte(key)) != nil || inflight != nil && inflight.Get([]byte(key)) != nil {
+				return fmt.Errorf("%w: %s in %s", ErrItemExists, key, dst)
+			}
+
+			r, err := from.remove(key)
+			if err != nil {
+				return err
+			}
+
+			// Items taken out of a dead-letter channel leave their history
+			// behind, and don't pick up what an earlier item of the same key
+			// left in dst.
+			for _, channel := range []string{src, dst} {
+				if err := forgetitem(tx, channel, key); err != nil {
+					return err
+				}
+			}
+
+			if err := admit(tx, to, r); err != nil {
+				return err
+			}
+
+			if err := to.put(r); err != nil {
+				return err
+			}
+			moved++
+		}
+
+		if err := incr(tx, bucketStats, "moved_out."+src, moved); err != nil {
+			return err
+		}
+
+		s.signal(tx, dst)
+		return incr(tx, bucketStats, "moved_in."+dst, moved)
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,95 @@
+package tinyq
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMoveItemsFiltered(t *testing.T) {
+	q := newtestq(t, nil)
+
+	push(t, q, "src", "a1", "one", WithTTL(time.Hour))
+	push(t, q, "src", "a2", "two", WithTTL(time.Hour))
+	push(t, q, "src", "b1", "three", WithTTL(time.Hour))
+	push(t, q, "src", "a3", "four")
+
+	moved, err := q.MoveItems("src", "dst", KeyPrefix("a"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 2 {
+		t.Fatalf("moved %d items, want 2", moved)
+	}
+
+	// The moved items are watched under dst only.
+	if n := expiries(t, q); n != 3 {
+		t.Fatalf("%d expiry entries, want 3", n)
+	}
+
+	if got := drain(t, q, "dst"); len(got) != 2 || got["a1"] != "one" || got["a2"] != "two" {
+		t.Fatalf("dst holds %v", got)
+	}
+
+	if got := drain(t, q, "src"); len(got) != 2 || got["b1"] != "three" || got["a3"] != "four" {
+		t.Fatalf("src holds %v", got)
+	}
+}
+
+func TestMoveItemsCollision(t *testing.T) {
+	q := newtestq(t, nil)
+
+	push(t, q, "src", "k0", "src-0")
+	push(t, q, "src", "k1", "src-1")
+	push(t, q, "dst", "k1", "dst-1")
+
+	moved, err := q.MoveItems("src", "dst", nil, 0)
+	if !errors.Is(err, ErrItemExists) {
+		t.Fatalf("move returned %v, want ErrItemExists", err)
+	}
+	if moved != 0 {
+		t.Fatalf("moved %d items, want 0", moved)
+	}
+
+	if got := drain(t, q, "dst"); len(got) != 1 || got["k1"] != "dst-1" {
+		t.Fatalf("dst holds %v", got)
+	}
+
+	if got := drain(t, q, "src"); len(got) != 2 || got["k0"] != "src-0" || got["k1"] != "src-1" {
+		t.Fatalf("src holds %v", got)
+	}
+}
+
+func TestMoveItemsStartOver(t *testing.T) {
+	q := newtestq(t, nil)
+
+	// An earlier a in dst was delivered once before dst was cleared, its
+	// attempt is still recorded.
+	push(t, q, "dst", "a", "old")
+	if err := q.Nack(popped(t, q, "dst", 1)[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.ClearChannel("dst"); err != nil {
+		t.Fatal(err)
+	}
+
+	push(t, q, "src", "a", "new")
+	push(t, q, "src", "b", "two")
+	if _, err := q.MoveItems("src", "dst", KeyPrefix("a"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := q.PopItems("dst", 1)
+	if err != nil || len(items) != 1 {
+		t.Fatalf("popped %v: %v", items, err)
+	}
+	if items[0].Attempts != 1 {
+		t.Fatalf("moved item delivered with %d attempts, want 1", items[0].Attempts)
+	}
+
+	// A key in flight in dst is taken like a queued one.
+	push(t, q, "src", "a", "again")
+	if _, err := q.MoveItems("src", "dst", nil, 0); !errors.Is(err, ErrItemExists) {
+		t.Fatalf("move returned %v, want ErrItemExists", err)
+	}
+}
@@ -0,0 +1,78 @@
+package tinyq
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+const (
+	defaultPeekLimit = 100
+	maxPeekLimit     = 1000
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page is a slice of a channel's items returned by Peek.
+type Page struct {
+	Items []*Item `json:"items"`
+	// Cursor continues after the last item of the page. It is empty once
+	// the end of the channel is reached.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// Peek returns up to limit items of a channel in delivery order, starting
+// after the cursor of a previous page, without removing or reserving them.
+// An empty cursor starts at the front of the channel.
+func (s *tinyQ) Peek(channel, cursor string, limit int) (*Page, error) {
+	if limit <= 0 {
+		limit = defaultPeekLimit
+	}
+
+	if limit > maxPeekLimit {
+		limit = maxPeekLimit
+	}
+
+	var after []byte
+	if len(cursor) > 0 {
+		var err error
+		if after, err = hex.DecodeString(cursor); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	page := &Page{Items: []*Item{}}
+	err := s.db.View(func(tx Tx) error {
+		c, err := openchannel(tx, channel, false)
+		if err != nil || c == nil {
+			return err
+		}
+
+		records, last, err := c.page(after, limit)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range records {
+			d, err := getDelivery(tx, channel, []byte(r.Key))
+			if err != nil {
+				return err
+			}
+
+			item := r.item(channel)
+			item.delivered(d.Attempts)
+			page.Items = append(page.Items, item)
+		}
+
+		if last != nil {
+			page.Cursor = hex.EncodeToString(last)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
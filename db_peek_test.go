@@ -0,0 +1,83 @@
+package tinyq
+
+import (
+	"fmt"
+	"testing"
+)
+
+// fill pushes n items keyed k0000, k0001, ... to channel in one batch.
+func fill(t *testing.T, q TinyQ, channel string, n int) {
+	t.Helper()
+
+	items := make([]*Item, n)
+	for i := range items {
+		items[i] = &Item{Channel: channel, Key: fmt.Sprintf("k%04d", i)}
+	}
+
+	if _, err := q.PushBatch(items); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPeekPages(t *testing.T) {
+	q := newtestq(t, nil)
+	fill(t, q, "jobs", 5)
+
+	var keys []string
+	var cursor string
+	for pages := 1; ; pages++ {
+		page, err := q.Peek("jobs", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Items) > 2 {
+			t.Fatalf("page %d holds %d items, want at most 2", pages, len(page.Items))
+		}
+
+		for _, item := range page.Items {
+			keys = append(keys, item.Key)
+		}
+
+		if cursor = page.Cursor; len(cursor) == 0 {
+			break
+		}
+
+		if pages > 3 {
+			t.Fatalf("still paging after %v", keys)
+		}
+	}
+
+	if fmt.Sprint(keys) != "[k0000 k0001 k0002 k0003 k0004]" {
+		t.Fatalf("peeked %v", keys)
+	}
+
+	// Peeking leaves the items queued.
+	if n := count(t, q, "jobs"); n != 5 {
+		t.Fatalf("jobs holds %d items after peeking, want 5", n)
+	}
+
+	if _, err := q.Peek("jobs", "not hex", 2); err != ErrInvalidCursor {
+		t.Fatalf("peek returned %v, want ErrInvalidCursor", err)
+	}
+
+	if page, err := q.Peek("missing", "", 2); err != nil || len(page.Items) != 0 || len(page.Cursor) != 0 {
+		t.Fatalf("peeked %+v from a missing channel: %v", page, err)
+	}
+}
+
+func TestPeekLimit(t *testing.T) {
+	q := newtestq(t, nil)
+	fill(t, q, "jobs", maxPeekLimit+1)
+
+	for _, limit := range []struct{ asked, want int }{{0, defaultPeekLimit}, {-1, defaultPeekLimit}, {maxPeekLimit + 1, maxPeekLimit}} {
+		page, err := q.Peek("jobs", "", limit.asked)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Items) != limit.want || len(page.Cursor) == 0 {
+			t.Fatalf("a limit of %d peeked %d items, want %d", limit.asked, len(page.Items), limit.want)
+		}
+	}
+}
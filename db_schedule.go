@@ -0,0 +1,208 @@
+package tinyq
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
+
+const (
+	bucketSchedule = "internal:schedule"
+	// fullRetryDelay is how long a due item waits before it is promoted
+	// again when its channel is full.
+	fullRetryDelay = 5 * time.Second
+)
+
+// scheduled is the value stored for every item waiting for its due time.
+// Items scheduled before Entry was kept only have the Item string.
+type scheduled struct {
+	Entry    *Item         `json:"entry,omitempty"`
+	Item     string        `json:"item,omitempty"`
+	Priority int           `json:"priority,omitempty"`
+	TTL      time.Duration `json:"ttl,omitempty"`
+}
+
+func (sc *scheduled) item() *Item {
+	if sc.Entry != nil {
+		return sc.Entry
+	}
+
+	return ParseItem(sc.Item)
+}
+
+// options returns the push options of the item. A TTL starts counting once
+// the item becomes poppable.
+func (sc *scheduled) options() *pushOptions {
+	return &pushOptions{priority: sc.Priority, ttl: sc.TTL}
+}
+
+func decodescheduled(v []byte) *scheduled {
+	var sc scheduled
+	if err := json.Unmarshal(v, &sc); err != nil {
+		// Items scheduled before options were kept are stored as is.
+		return &scheduled{Item: string(v)}
+	}
+	return &sc
+}
+
+// schedulekey orders scheduled items by due time; the sequence keeps keys
+// unique when several items are due at the same instant.
+func schedulekey(at time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(at.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func scheduledue(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
+
+// PushAt stores a "channel.key.payload" item that only becomes poppable at the given time.
+func (s *tinyQ) PushAt(item string, at time.Time, opts ...PushOption) error {
+	return s.PushItemAt(ParseItem(item), at, opts...)
+}
+
+// PushItemAt stores an item that only becomes poppable at the given time.
+func (s *tinyQ) PushItemAt(item *Item, at time.Time, opts ...PushOption) error {
+	if err := item.validate(); err != nil {
+		return err
+	}
+
+	if !at.After(time.Now()) {
+		return s.PushItem(item, opts...)
+	}
+
+	o := newPushOptions(opts)
+	targets, err := o.targets(item)
+	if err != nil {
+		return err
+	}
+
+	var entries [][]byte
+	for _, target := range targets {
+		entry := &Item{Channel: target.Channel, Key: target.Key, Payload: target.Payload, Headers: target.Headers}
+		encoded, err := json.Marshal(&scheduled{Entry: entry, Priority: o.priority, TTL: o.ttl})
+		if err != nil {
+			return err
+		}
+		entries = append(entries, encoded)
+	}
+
+	id, window := s.dedupid(item.Channel, item.Key, o)
+	return s.pushwrite(func(tx Tx) error {
+		if len(id) > 0 {
+			if err := remember(tx, id, window, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketSchedule))
+		if err != nil {
+			return err
+		}
+
+		for _, encoded := range entries {
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+
+			if err := bucket.Put(schedulekey(at, seq), encoded); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// PushAfter stores an item that only becomes poppable once delay has passed.
+func (s *tinyQ) PushAfter(item string, delay time.Duration, opts ...PushOption) error {
+	return s.PushAt(item, time.Now().Add(delay), opts...)
+}
+
+// PromoteDue moves every scheduled item that is due into its channel.
+func (s *tinyQ) PromoteDue() (int, error) {
+	now := time.Now()
+
+	// Peek at the earliest item first, so an idle queue never pays for a write.
+	var due bool
+	err := s.db.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte(bucketSchedule))
+		if bucket == nil {
+			return nil
+		}
+
+		k, _ := bucket.Cursor().First()
+		due = k != nil && !scheduledue(k).After(now)
+		return nil
+	})
+
+	if err != nil || !due {
+		return 0, err
+	}
+
+	var promoted int
+	err = s.update(func(tx Tx) error {
+		promoted = 0
+		bucket := tx.Bucket([]byte(bucketSchedule))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil && !scheduledue(k).After(now); k, v = c.First() {
+			value := append([]byte(nil), v...)
+			sc := decodescheduled(value)
+			err := s.push(tx, sc.item(), sc.options())
+			if err != nil && err != ErrChannelFull && err != errDropped {
+				return err
+			}
+
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+
+			// An item due for a full channel waits for room instead of
+			// holding up the items due after it.
+			if err == ErrChannelFull {
+				seq, err := bucket.NextSequence()
+				if err != nil {
+					return err
+				}
+
+				if err := bucket.Put(schedulekey(now.Add(fullRetryDelay), seq), value); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err == nil {
+				promoted++
+			}
+		}
+
+		return nil
+	})
+
+	return promoted, err
+}
+
+// Scheduled returns the number of items per channel that are waiting for their due time.
+func (s *tinyQ) Scheduled() (map[string]int, error) {
+	var counts = make(map[string]int)
+	err := s.db.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte(bucketSchedule))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			counts[decodescheduled(v).item().Channel]++
+			return nil
+		})
+	})
+
+	return counts, err
+}
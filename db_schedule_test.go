@@ -0,0 +1,116 @@
+package tinyq
+
+import (
+	"testing"
+	"time"
+)
+
+// nextdue returns when the earliest scheduled item is due.
+func nextdue(t *testing.T, q *tinyQ) time.Time {
+	t.Helper()
+
+	var due time.Time
+	err := q.db.View(func(tx Tx) error {
+		if bucket := tx.Bucket([]byte(bucketSchedule)); bucket != nil {
+			if k, _ := bucket.Cursor().First(); k != nil {
+				due = scheduledue(k)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return due
+}
+
+func TestPromoteDue(t *testing.T) {
+	q := newtestq(t, nil)
+
+	if err := q.PushAfter("jobs.a.one", 20*time.Millisecond, WithPriority(MaxPriority)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := q.PromoteDue(); err != nil || n != 0 {
+		t.Fatalf("promoted %d items before they were due: %v", n, err)
+	}
+
+	if scheduled, _ := q.Scheduled(); scheduled["jobs"] != 1 {
+		t.Fatalf("scheduled %v, want 1 item for jobs", scheduled)
+	}
+
+	if n := count(t, q, "jobs"); n != 0 {
+		t.Fatalf("jobs holds %d items before a is due", n)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if n, err := q.PromoteDue(); err != nil || n != 1 {
+		t.Fatalf("promoted %d items once due, want 1: %v", n, err)
+	}
+
+	if scheduled, _ := q.Scheduled(); len(scheduled) != 0 {
+		t.Fatalf("scheduled %v after promotion", scheduled)
+	}
+
+	items, err := q.PopItems("jobs", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Key != "a" || items[0].Priority != MaxPriority {
+		t.Fatalf("popped %+v, want a with its priority", items)
+	}
+}
+
+func TestPromoteDueChannelFull(t *testing.T) {
+	q := newtestq(t, nil)
+
+	limit(t, q, "jobs", &ChannelConfig{MaxLength: 1, Overflow: OverflowReject})
+	push(t, q, "jobs", "a", "one")
+	if err := q.PushAfter("jobs.b.two", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	before := time.Now()
+	if n, err := q.PromoteDue(); err != nil || n != 0 {
+		t.Fatalf("promoted %d items into a full channel: %v", n, err)
+	}
+
+	// The item is kept and tried again later.
+	if scheduled, _ := q.Scheduled(); scheduled["jobs"] != 1 {
+		t.Fatalf("scheduled %v, want b kept for jobs", scheduled)
+	}
+
+	if due := nextdue(t, q); due.Before(before.Add(fullRetryDelay)) || due.After(time.Now().Add(fullRetryDelay)) {
+		t.Fatalf("b is due again in %v, want %v", time.Until(due), fullRetryDelay)
+	}
+}
+
+func TestPromoteDueLegacyEntry(t *testing.T) {
+	q := newtestq(t, nil)
+
+	// Entries scheduled before options were kept hold the bare item string.
+	err := q.db.Update(func(tx Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketSchedule))
+		if err != nil {
+			return err
+		}
+		return bucket.Put(schedulekey(time.Now().Add(-time.Second), 1), []byte("jobs.a.one"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scheduled, _ := q.Scheduled(); scheduled["jobs"] != 1 {
+		t.Fatalf("scheduled %v, want 1 item for jobs", scheduled)
+	}
+
+	if n, err := q.PromoteDue(); err != nil || n != 1 {
+		t.Fatalf("promoted %d items, want 1: %v", n, err)
+	}
+
+	if got := drain(t, q, "jobs"); len(got) != 1 || got["a"] != "one" {
+		t.Fatalf("jobs holds %v", got)
+	}
+}
@@ -3,42 +3,69 @@ package tinyq
 import (
 	"strconv"
 	"strings"
-
-	"go.etcd.io/bbolt"
 )
 
 type ChannelStats struct {
-	Channel  string         `json:"channel"`
-	Stats    map[string]int `json:"stats"`
-	IsPaused bool           `json:"is_paused"`
-	Count    int            `json:"count"`
+	Channel     string         `json:"channel"`
+	Stats       map[string]int `json:"stats"`
+	IsPaused    bool           `json:"is_paused"`
+	Count       int            `json:"count"`
+	InFlight    int            `json:"in_flight"`
+	DeadLetters int            `json:"dead_letters"`
+	Scheduled   int            `json:"scheduled"`
+	Priorities  map[int]int    `json:"priorities,omitempty"`
 }
 
 func (s *tinyQ) Stats(appname string) ([]*ChannelStats, error) {
 	stats := make(map[string]int)
+	counters := make(map[string]map[string]int)
 
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err := s.db.View(func(tx Tx) error {
 		bucket := tx.Bucket([]byte(appname))
-		if bucket == nil {
-			return nil //no stats yet
+		if bucket != nil {
+			c := bucket.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				key := string(k)
+				value := string(v)
+				stats[key], _ = strconv.Atoi(string(value))
+			}
 		}
 
-		c := bucket.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			key := string(k)
-			value := string(v)
-			stats[key], _ = strconv.Atoi(string(value))
+		// Counters kept by the queue itself are stored as "<event>.<channel>".
+		internal := tx.Bucket([]byte(bucketStats))
+		if internal == nil {
+			return nil //no stats yet
 		}
 
-		return nil
+		return internal.ForEach(func(k, v []byte) error {
+			event, channel, ok := strings.Cut(string(k), ".")
+			if !ok {
+				return nil
+			}
+
+			if counters[channel] == nil {
+				counters[channel] = make(map[string]int)
+			}
+
+			counters[channel][event], _ = strconv.Atoi(string(v))
+			return nil
+		})
 	})
 
 	channels, _ := s.ListChannels()
+	scheduled, _ := s.Scheduled()
+	for ch := range scheduled {
+		if _, ok := channels[ch]; !ok {
+			channels[ch] = 0
+		}
+	}
+
 	var statsmap []*ChannelStats
 	for ch, count := range channels {
 
 		ispaused, _ := s.IsChannelPaused(ch)
-		var one = &ChannelStats{Stats: make(map[string]int), Count: count, Channel: ch, IsPaused: ispaused}
+		inflight, _ := s.InFlight(ch)
+		var one = &ChannelStats{Stats: make(map[string]int), Count: count, Channel: ch, IsPaused: ispaused, InFlight: inflight}
 
 		for k, sm := range stats {
 			if strings.Contains(k, ch) {
@@ -46,6 +73,17 @@ func (s *tinyQ) Stats(appname string) ([]*ChannelStats, error) {
 			}
 		}
 
+		for event, sm := range counters[ch] {
+			one.Stats[event] = sm
+		}
+
+		if dlq, ok := channels[DeadLetterChannel(ch)]; ok {
+			one.DeadLetters = dlq
+		}
+
+		one.Scheduled = scheduled[ch]
+		one.Priorities, _ = s.priorities(ch)
+
 		statsmap = append(statsmap, one)
 	}
 
@@ -55,3 +93,19 @@ func (s *tinyQ) Stats(appname string) ([]*ChannelStats, error) {
 
 	return statsmap, nil
 }
+
+// priorities counts the queued items of a channel per priority.
+func (s *tinyQ) priorities(channel string) (map[int]int, error) {
+	var counts map[int]int
+	err := s.db.View(func(tx Tx) error {
+		c, err := openchannel(tx, channel, false)
+		if err != nil || c == nil {
+			return err
+		}
+
+		counts = c.priorities()
+		return nil
+	})
+
+	return counts, err
+}
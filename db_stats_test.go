@@ -0,0 +1,30 @@
+package tinyq
+
+import "testing"
+
+func TestStatsPriorities(t *testing.T) {
+	q := newtestq(t, nil)
+
+	push(t, q, "jobs", "a", "one", WithPriority(MaxPriority))
+	push(t, q, "jobs", "b", "two")
+	push(t, q, "jobs", "c", "three", WithPriority(MaxPriority))
+	legacychannel(t, q, "old", map[string]string{"x": "four", "y": "five"})
+
+	stats, err := q.Stats("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byname := make(map[string]*ChannelStats)
+	for _, one := range stats {
+		byname[one.Channel] = one
+	}
+
+	if got := byname["jobs"].Priorities; len(got) != 2 || got[MaxPriority] != 2 || got[MinPriority] != 1 {
+		t.Fatalf("jobs priorities %v", got)
+	}
+
+	if got := byname["old"].Priorities; len(got) != 1 || got[MinPriority] != 2 {
+		t.Fatalf("legacy priorities %v", got)
+	}
+}
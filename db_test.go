@@ -0,0 +1,225 @@
+package tinyq
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// openq opens an app kept in a temporary directory, closed when the test ends.
+func openq(t *testing.T, opt *Options) TinyQ {
+	t.Helper()
+
+	Rootpath = t.TempDir()
+	if opt == nil {
+		opt = &Options{}
+	}
+	if len(opt.Appname) == 0 {
+		opt.Appname = "test"
+	}
+
+	q := NewTinyQ(opt)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+
+	return q
+}
+
+// popped pops count items of channel and fails unless exactly count came out.
+func popped(t *testing.T, q TinyQ, channel string, count int) []string {
+	t.Helper()
+
+	items, err := q.Pop(channel, count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != count {
+		t.Fatalf("popped %v from %s, want %d items", items, channel, count)
+	}
+
+	return items
+}
+
+// newtestq opens an in-memory app that is closed when the test ends.
+func newtestq(t *testing.T, opt *Options) *tinyQ {
+	t.Helper()
+
+	if opt == nil {
+		opt = &Options{}
+	}
+	if len(opt.Appname) == 0 {
+		opt.Appname = "test"
+	}
+
+	q := NewMemoryTinyQ(opt)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { q.Close() })
+
+	return q.(*tinyQ)
+}
+
+func push(t *testing.T, q TinyQ, channel, key, payload string, opts ...PushOption) {
+	t.Helper()
+
+	if err := q.PushItem(&Item{Channel: channel, Key: key, Payload: []byte(payload)}, opts...); err != nil {
+		t.Fatalf("push %s/%s: %v", channel, key, err)
+	}
+}
+
+// drain pops every item of channel and returns their keys and payloads.
+func drain(t *testing.T, q TinyQ, channel string) map[string]string {
+	t.Helper()
+
+	items, err := q.PopItems(channel, 1000)
+	if err != nil {
+		t.Fatalf("pop %s: %v", channel, err)
+	}
+
+	got := make(map[string]string)
+	for _, item := range items {
+		got[item.Key] = string(item.Payload)
+	}
+
+	return got
+}
+
+// expiries returns the number of items watched by the expiry sweeper.
+func expiries(t *testing.T, q *tinyQ) int {
+	t.Helper()
+
+	var n int
+	err := q.db.View(func(tx Tx) error {
+		if bucket := tx.Bucket([]byte(bucketExpiry)); bucket != nil {
+			return bucket.ForEach(func(k, v []byte) error {
+				n++
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestPushWithChannels(t *testing.T) {
+	q := newtestq(t, &Options{DedupWindow: time.Minute})
+
+	item := &Item{Channel: "orders", Key: "o1", Payload: []byte("one")}
+	if err := q.PushItem(item, WithChannels("billing", "shipping")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The copies are deduplicated as the item itself.
+	if err := q.PushItem(item, WithChannels("billing", "shipping")); err != ErrDuplicate {
+		t.Fatalf("second push returned %v, want ErrDuplicate", err)
+	}
+
+	for _, channel := range []string{"billing", "shipping"} {
+		if got := drain(t, q, channel); len(got) != 1 || got["o1"] != "one" {
+			t.Fatalf("%s holds %v", channel, got)
+		}
+	}
+
+	if n, _ := q.Count("orders"); n != 0 {
+		t.Fatalf("orders holds %d items, want 0", n)
+	}
+}
+
+func TestPushWithChannelsAtomic(t *testing.T) {
+	q := newtestq(t, nil)
+	if err := q.SetChannelConfig("shipping", &ChannelConfig{MaxLength: 1, Overflow: OverflowReject}); err != nil {
+		t.Fatal(err)
+	}
+	push(t, q, "shipping", "s0", "full")
+
+	item := &Item{Channel: "orders", Key: "o1", Payload: []byte("one")}
+	if err := q.PushItem(item, WithChannels("billing", "shipping")); err != ErrChannelFull {
+		t.Fatalf("push returned %v, want ErrChannelFull", err)
+	}
+
+	if n, _ := q.Count("billing"); n != 0 {
+		t.Fatalf("billing holds %d items after a failed push", n)
+	}
+
+	// Scheduled copies are stored together too.
+	at := time.Now().Add(time.Millisecond)
+	if err := q.PushItemAt(item, at, WithChannels("billing", "audit")); err != nil {
+		t.Fatal(err)
+	}
+
+	if scheduled, _ := q.Scheduled(); scheduled["billing"] != 1 || scheduled["audit"] != 1 {
+		t.Fatalf("scheduled %v", scheduled)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if promoted, err := q.PromoteDue(); err != nil || promoted != 2 {
+		t.Fatalf("promoted %d items, %v; want 2", promoted, err)
+	}
+}
+
+func TestPushBatch(t *testing.T) {
+	q := newtestq(t, &Options{DedupWindow: time.Minute})
+
+	push(t, q, "jobs", "a", "one")
+
+	// Items pushed within the dedup window are left out of the count.
+	batch := []*Item{
+		{Channel: "jobs", Key: "a", Payload: []byte("again")},
+		{Channel: "jobs", Key: "b", Payload: []byte("two")},
+		{Channel: "mail", Key: "c", Payload: []byte("three")},
+	}
+	if n, err := q.PushBatch(batch); err != nil || n != 2 {
+		t.Fatalf("pushed %d items, want 2: %v", n, err)
+	}
+
+	if got := drain(t, q, "jobs"); len(got) != 2 || got["a"] != "one" || got["b"] != "two" {
+		t.Fatalf("jobs holds %v", got)
+	}
+
+	// An idempotency key covers the batch as a whole.
+	retried := []*Item{{Channel: "jobs", Key: "d", Payload: []byte("four")}}
+	if n, err := q.PushBatch(retried, WithIdempotencyKey("batch-1")); err != nil || n != 1 {
+		t.Fatalf("pushed %d items, want 1: %v", n, err)
+	}
+
+	if _, err := q.PushBatch(retried, WithIdempotencyKey("batch-1")); err != ErrDuplicate {
+		t.Fatalf("retried batch returned %v, want ErrDuplicate", err)
+	}
+}
+
+func TestPushBatchAtomic(t *testing.T) {
+	q := newtestq(t, nil)
+	limit(t, q, "mail", &ChannelConfig{MaxLength: 1, Overflow: OverflowReject})
+
+	// The last item does not fit, so none of the batch is written.
+	batch := []*Item{
+		{Channel: "jobs", Key: "a", Payload: []byte("one")},
+		{Channel: "mail", Key: "b", Payload: []byte("two")},
+		{Channel: "mail", Key: "c", Payload: []byte("three")},
+	}
+	if n, err := q.PushBatch(batch); err != ErrChannelFull || n != 0 {
+		t.Fatalf("pushed %d items, %v; want ErrChannelFull", n, err)
+	}
+
+	if n := count(t, q, "jobs") + count(t, q, "mail"); n != 0 {
+		t.Fatalf("%d items written by a failed batch", n)
+	}
+
+	// An invalid item is refused before anything is written.
+	batch[2] = &Item{Channel: "mail"}
+	if _, err := q.PushBatch(batch); !errors.Is(err, ErrInvalidItem) {
+		t.Fatalf("push returned %v, want ErrInvalidItem", err)
+	}
+
+	if n := count(t, q, "jobs"); n != 0 {
+		t.Fatalf("jobs holds %d items after an invalid batch", n)
+	}
+}
@@ -0,0 +1,185 @@
+package tinyq
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+)
+
+const bucketTopics = "internal:topics"
+
+var ErrNoBindings = errors.New("topic has no bound channels")
+
+// bindingkey groups the bindings of a topic by prefixing the channel with
+// the topic and a zero byte.
+func bindingkey(topic, channel string) []byte {
+	return append(append([]byte(topic), 0), channel...)
+}
+
+func validbinding(topic, channel string) error {
+	if len(topic) == 0 || len(channel) == 0 {
+		return errors.New("topic and channel are required")
+	}
+
+	if isInternalChannel(channel) || strings.IndexByte(topic+channel, 0) >= 0 {
+		return errors.New("invalid topic or channel")
+	}
+
+	return nil
+}
+
+// BindTopic makes every item published to topic be copied into channel.
+func (s *tinyQ) BindTopic(topic, channel string) error {
+	if err := validbinding(topic, channel); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketTopics))
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(bindingkey(topic, channel), nil)
+	})
+}
+
+func (s *tinyQ) UnbindTopic(topic, channel string) error {
+	return s.db.Update(func(tx Tx) error {
+		bucket := tx.Bucket([]byte(bucketTopics))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.Delete(bindingkey(topic, channel))
+	})
+}
+
+func bindings(tx Tx, topic string) []string {
+	bucket := tx.Bucket([]byte(bucketTopics))
+	if bucket == nil {
+		return nil
+	}
+
+	var channels []string
+	prefix := bindingkey(topic, "")
+	c := bucket.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		channels = append(channels, string(k[len(prefix):]))
+	}
+
+	return channels
+}
+
+// TopicBindings returns the channels bound to topic.
+func (s *tinyQ) TopicBindings(topic string) ([]string, error) {
+	var channels []string
+	err := s.db.View(func(tx Tx) error {
+		channels = bindings(tx, topic)
+		return nil
+	})
+
+	return channels, err
+}
+
+// Topics returns every topic with the channels bound to it.
+func (s *tinyQ) Topics() (map[string][]string, error) {
+	var topics = make(map[string][]string)
+	err := s.db.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte(bucketTopics))
+		if bucket == nil {
+			return nil
+		}
+
+		return bucket.ForEach(func(k, v []byte) error {
+			topic, channel, _ := bytes.Cut(k, []byte{0})
+			topics[string(topic)] = append(topics[string(topic)], string(channel))
+			return nil
+		})
+	})
+
+	return topics, err
+}
+
+// unbindchannel drops every binding to a deleted channel.
+func unbindchannel(tx Tx, channel string) error {
+	bucket := tx.Bucket([]byte(bucketTopics))
+	if bucket == nil {
+		return nil
+	}
+
+	var stale [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		if _, bound, _ := bytes.Cut(k, []byte{0}); string(bound) == channel {
+			stale = append(stale, append([]byte(nil), k...))
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, k := range stale {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Publish copies an item into every channel bound to the topic named by its
+// Channel, in a single transaction. Every copy is an independent item, so
+// each channel is consumed at its own pace. It returns the number of
+// channels the item was written to.
+func (s *tinyQ) Publish(item *Item, opts ...PushOption) (int, error) {
+	if err := item.validate(); err != nil {
+		return 0, err
+	}
+
+	// Every copy shares the trace id of the published item.
+	item = item.traced()
+	topic := item.Channel
+	o := newPushOptions(opts)
+	id, window := s.dedupid(topic, item.Key, o)
+
+	var published int
+	err := s.pushwrite(func(tx Tx) error {
+		published = 0
+		channels := bindings(tx, topic)
+		if len(channels) == 0 {
+			return ErrNoBindings
+		}
+
+		if len(id) > 0 {
+			if err := remember(tx, id, window, time.Now()); err != nil {
+				return err
+			}
+		}
+
+		for _, channel := range channels {
+			copied := *item
+			copied.Channel = channel
+
+			err := s.push(tx, &copied, o)
+			if err == errDropped {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+			published++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return published, nil
+}
@@ -0,0 +1,84 @@
+package tinyq
+
+import (
+	"errors"
+	"testing"
+)
+
+func bind(t *testing.T, q TinyQ, topic string, channels ...string) {
+	t.Helper()
+
+	for _, channel := range channels {
+		if err := q.BindTopic(topic, channel); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	q := newtestq(t, nil)
+	bind(t, q, "orders", "billing", "shipping")
+
+	n, err := q.Publish(&Item{Channel: "orders", Key: "o1", Payload: []byte("one")})
+	if err != nil || n != 2 {
+		t.Fatalf("published to %d channels, want 2: %v", n, err)
+	}
+
+	billing, err := q.PopItems("billing", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shipping, err := q.PopItems("shipping", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(billing) != 1 || len(shipping) != 1 || string(billing[0].Payload) != "one" || string(shipping[0].Payload) != "one" {
+		t.Fatalf("billing got %v, shipping got %v", billing, shipping)
+	}
+
+	// Both copies are traced as the same published item.
+	if trace := billing[0].Headers[HeaderTraceID]; len(trace) == 0 || shipping[0].Headers[HeaderTraceID] != trace {
+		t.Fatalf("trace ids %q and %q", trace, shipping[0].Headers[HeaderTraceID])
+	}
+}
+
+func TestPublishAtomic(t *testing.T) {
+	q := newtestq(t, nil)
+	bind(t, q, "orders", "billing", "shipping")
+
+	limit(t, q, "shipping", &ChannelConfig{MaxLength: 1, Overflow: OverflowReject})
+	push(t, q, "shipping", "s", "queued")
+
+	// A copy that is refused takes the others with it.
+	_, err := q.Publish(&Item{Channel: "orders", Key: "o1", Payload: []byte("one")})
+	if !errors.Is(err, ErrChannelFull) {
+		t.Fatalf("publish returned %v, want ErrChannelFull", err)
+	}
+
+	if n := count(t, q, "billing"); n != 0 {
+		t.Fatalf("billing holds %d items after a refused publish", n)
+	}
+}
+
+func TestPublishNoBindings(t *testing.T) {
+	q := newtestq(t, nil)
+
+	item := &Item{Channel: "orders", Key: "o1", Payload: []byte("one")}
+	if _, err := q.Publish(item); !errors.Is(err, ErrNoBindings) {
+		t.Fatalf("publish returned %v, want ErrNoBindings", err)
+	}
+
+	bind(t, q, "orders", "billing")
+	if err := q.UnbindTopic("orders", "billing"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.Publish(item); !errors.Is(err, ErrNoBindings) {
+		t.Fatalf("publish after unbinding returned %v, want ErrNoBindings", err)
+	}
+
+	if n := count(t, q, "billing"); n != 0 {
+		t.Fatalf("billing holds %d items", n)
+	}
+}
@@ -0,0 +1,196 @@
+package tinyq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+const (
+	bucketExpiry     = "internal:expiry"
+	bucketChannelTTL = "internal:channel_ttl"
+)
+
+// expirykey orders items with a TTL by expiry time, followed by the channel
+// and the item key separated by a zero byte.
+func expirykey(at time.Time, channel, key string) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint64(at.UnixNano()))
+	buf.WriteString(channel)
+	buf.WriteByte(0)
+	buf.WriteString(key)
+	return buf.Bytes()
+}
+
+func parseexpirykey(k []byte) (time.Time, string, string) {
+	at := time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+	channel, key, _ := bytes.Cut(k[8:], []byte{0})
+	return at, string(channel), string(key)
+}
+
+// watchexpiry lets the sweeper find an item once its TTL runs out.
+func watchexpiry(tx Tx, channel string, r *record) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketExpiry))
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(expirykey(r.ExpiresAt, channel, r.Key), nil)
+}
+
+// unwatchexpiry forgets the expiry of an item leaving its channel.
+func unwatchexpiry(tx Tx, channel string, r *record) error {
+	bucket := tx.Bucket([]byte(bucketExpiry))
+	if bucket == nil || r.ExpiresAt.IsZero() {
+		return nil
+	}
+
+	return bucket.Delete(expirykey(r.ExpiresAt, channel, r.Key))
+}
+
+func channelttl(tx Tx, channel string) (time.Duration, error) {
+	bucket := tx.Bucket([]byte(bucketChannelTTL))
+	if bucket == nil {
+		return 0, nil
+	}
+
+	value := bucket.Get([]byte(channel))
+	if value == nil {
+		return 0, nil
+	}
+
+	return time.ParseDuration(string(value))
+}
+
+// SetChannelTTL sets the TTL of items pushed to channel without their own
+// TTL. A zero ttl removes the default.
+func (s *tinyQ) SetChannelTTL(channel string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return s.Delete(bucketChannelTTL, channel)
+	}
+
+	return s.Set(bucketChannelTTL, channel, ttl.String())
+}
+
+func (s *tinyQ) ChannelTTL(channel string) (time.Duration, error) {
+	var ttl time.Duration
+	err := s.db.View(func(tx Tx) error {
+		var err error
+		ttl, err = channelttl(tx, channel)
+		return err
+	})
+
+	return ttl, err
+}
+
+// expire counts an item that ran out of time and routes it to the expired
+// channel, if one is configured.
+func (s *tinyQ) expire(tx Tx, channel string, r *record) error {
+	if err := incr(tx, bucketStats, "expired."+channel, 1); err != nil {
+		return err
+	}
+
+	if len(s.opt.ExpiredChannel) == 0 || channel == s.opt.ExpiredChannel {
+		return nil
+	}
+
+	expired, err := openchannel(tx, s.opt.ExpiredChannel, true)
+	if err != nil {
+		return err
+	}
+
+	dead := &record{Key: channel + ":" + r.Key, Payload: r.Payload, EnqueuedAt: time.Now()}
+	switch err := makeroom(tx, expired, dead); err {
+	case nil:
+	case errDropped:
+		return nil
+	case ErrChannelFull:
+		// The item is gone either way, a full expired channel only loses its copy.
+		return incr(tx, bucketStats, "dropped."+s.opt.ExpiredChannel, 1)
+	default:
+		return err
+	}
+
+	s.signal(tx, s.opt.ExpiredChannel)
+	return expired.put(dead)
+}
+
+// ExpireDue removes every queued item whose TTL ran out, including items of
+// channels nobody pops from.
+func (s *tinyQ) ExpireDue() (int, error) {
+	now := time.Now()
+
+	// Peek at the earliest expiry first, so an idle queue never pays for a write.
+	var due bool
+	err := s.db.View(func(tx Tx) error {
+		bucket := tx.Bucket([]byte(bucketExpiry))
+		if bucket == nil {
+			return nil
+		}
+
+		k, _ := bucket.Cursor().First()
+		if k != nil {
+			at, _, _ := parseexpirykey(k)
+			due = !now.Before(at)
+		}
+		return nil
+	})
+
+	if err != nil || !due {
+		return 0, err
+	}
+
+	var expired int
+	err = s.update(func(tx Tx) error {
+		expired = 0
+		bucket := tx.Bucket([]byte(bucketExpiry))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.First() {
+			at, channel, key := parseexpirykey(k)
+			if now.Before(at) {
+				break
+			}
+
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+
+			// The item may have been popped or pushed again with a new TTL
+			// since it was indexed.
+			items, err := openchannel(tx, channel, false)
+			if err != nil {
+				return err
+			}
+
+			if items == nil {
+				continue
+			}
+
+			r, err := items.get(key)
+			if err != nil {
+				return err
+			}
+
+			if r == nil || !r.expired(now) {
+				continue
+			}
+
+			if _, err := items.remove(key); err != nil {
+				return err
+			}
+
+			if err := s.expire(tx, channel, r); err != nil {
+				return err
+			}
+			expired++
+		}
+
+		return nil
+	})
+
+	return expired, err
+}
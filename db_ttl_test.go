@@ -0,0 +1,82 @@
+package tinyq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestItemTTL(t *testing.T) {
+	q := newtestq(t, nil)
+
+	push(t, q, "jobs", "a", "one", WithTTL(20*time.Millisecond))
+	push(t, q, "jobs", "b", "two")
+
+	time.Sleep(30 * time.Millisecond)
+
+	// A stale item is dropped by the pop that finds it.
+	if got := drain(t, q, "jobs"); len(got) != 1 || got["b"] != "two" {
+		t.Fatalf("jobs holds %v, want b only", got)
+	}
+
+	if expiries(t, q) != 0 {
+		t.Fatalf("%d expiries still watched", expiries(t, q))
+	}
+}
+
+func TestChannelTTL(t *testing.T) {
+	q := newtestq(t, nil)
+
+	if err := q.SetChannelTTL("jobs", 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, err := q.ChannelTTL("jobs"); err != nil || ttl != 20*time.Millisecond {
+		t.Fatalf("channel ttl %v: %v", ttl, err)
+	}
+
+	// An item's own TTL wins over the channel default.
+	push(t, q, "jobs", "a", "one")
+	push(t, q, "jobs", "b", "two", WithTTL(time.Hour))
+
+	time.Sleep(30 * time.Millisecond)
+	if n, err := q.ExpireDue(); err != nil || n != 1 {
+		t.Fatalf("expired %d items, want 1: %v", n, err)
+	}
+
+	if n := count(t, q, "jobs"); n != 1 {
+		t.Fatalf("jobs holds %d items, want 1", n)
+	}
+
+	if err := q.SetChannelTTL("jobs", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	push(t, q, "jobs", "c", "three")
+	if got := drain(t, q, "jobs"); len(got) != 2 || got["b"] != "two" || got["c"] != "three" {
+		t.Fatalf("jobs holds %v", got)
+	}
+}
+
+func TestExpiredChannel(t *testing.T) {
+	q := newtestq(t, &Options{ExpiredChannel: "expired"})
+
+	push(t, q, "jobs", "a", "one", WithTTL(10*time.Millisecond))
+
+	time.Sleep(20 * time.Millisecond)
+	if n, err := q.ExpireDue(); err != nil || n != 1 {
+		t.Fatalf("expired %d items, want 1: %v", n, err)
+	}
+
+	if expiries(t, q) != 0 {
+		t.Fatalf("%d expiries still watched", expiries(t, q))
+	}
+
+	if got := drain(t, q, "expired"); len(got) != 1 || got["jobs:a"] != "one" {
+		t.Fatalf("expired holds %v, want jobs:a", got)
+	}
+
+	// Sweeping again finds nothing.
+	if n, err := q.ExpireDue(); err != nil || n != 0 {
+		t.Fatalf("expired %d items on the second sweep: %v", n, err)
+	}
+}
@@ -3,31 +3,63 @@ package tinyq
 import (
 	"errors"
 	"strconv"
-
-	"go.etcd.io/bbolt"
 )
 
 func (s *tinyQ) Inc(b, k string) error {
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(b))
-		if err != nil {
+	return s.db.Update(func(tx Tx) error {
+		if err := keepkv(tx, b); err != nil {
 			return err
 		}
 
-		value := bucket.Get([]byte(k))
-		if value == nil {
-			value = []byte("0")
-		}
+		return incr(tx, b, k, 1)
+	})
+}
 
-		count, err := strconv.Atoi(string(value))
-		if err != nil {
-			return err
-		}
+// keepkv records that a bucket holds the values of Set or Inc, so it is not
+// taken for a legacy channel.
+func keepkv(tx Tx, b string) error {
+	if isInternalChannel(b) {
+		return nil
+	}
 
-		count++
-		return bucket.Put([]byte(k), []byte(strconv.Itoa(count)))
-	})
-	return err
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketKV))
+	if err != nil {
+		return err
+	}
+
+	if bucket.Get([]byte(b)) != nil {
+		return nil
+	}
+
+	return bucket.Put([]byte(b), []byte{1})
+}
+
+// iskv reports whether a bucket holds values rather than a channel, as it
+// was written by Set or Inc.
+func iskv(tx Tx, b string) bool {
+	bucket := tx.Bucket([]byte(bucketKV))
+	return bucket != nil && bucket.Get([]byte(b)) != nil
+}
+
+// incr adds n to the counter stored under k, inside an existing transaction.
+func incr(tx Tx, b, k string, n int) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(b))
+	if err != nil {
+		return err
+	}
+
+	value := bucket.Get([]byte(k))
+	if value == nil {
+		value = []byte("0")
+	}
+
+	count, err := strconv.Atoi(string(value))
+	if err != nil {
+		return err
+	}
+
+	count += n
+	return bucket.Put([]byte(k), []byte(strconv.Itoa(count)))
 }
 
 func (s *tinyQ) PauseChannel(channel string) error {
@@ -48,20 +80,40 @@ func (s *tinyQ) IsChannelPaused(channel string) (bool, error) {
 }
 
 func (s *tinyQ) ClearChannel(channel string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		bucket := tx.Bucket([]byte(channel))
-		if bucket == nil {
+	return s.update(func(tx Tx) error {
+		if tx.Bucket([]byte(channel)) == nil {
 			return errors.New("channel not found")
 		}
 
-		return bucket.ForEach(func(k, v []byte) error {
-			return bucket.Delete(k)
-		})
+		c, err := openchannel(tx, channel, true)
+		if err != nil {
+			return err
+		}
+
+		return c.clear()
 	})
 }
 
 func (s *tinyQ) DeleteChannel(channel string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	return s.db.Update(func(tx Tx) error {
+		for _, companion := range [][]byte{indexBucket(channel), inflightBucket(channel), attemptsBucket(channel), deadletterBucket(channel), migrateBucket(channel)} {
+			if err := tx.DeleteBucket(companion); err != nil && err != ErrBucketNotFound {
+				return err
+			}
+		}
+
+		if err := unbindchannel(tx, channel); err != nil {
+			return err
+		}
+
+		for _, b := range []string{bucketChannelFormat, bucketChannelSize} {
+			if bucket := tx.Bucket([]byte(b)); bucket != nil {
+				if err := bucket.Delete([]byte(channel)); err != nil {
+					return err
+				}
+			}
+		}
+
 		return tx.DeleteBucket([]byte(channel))
 	})
 }
@@ -69,13 +121,13 @@ func (s *tinyQ) DeleteChannel(channel string) error {
 func (s *tinyQ) ListChannels() (map[string]int, error) {
 
 	var channels = make(map[string]int)
-	err := s.db.View(func(tx *bbolt.Tx) error {
-		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+	err := s.db.View(func(tx Tx) error {
+		return tx.ForEach(func(name []byte, b Bucket) error {
 			if isInternalChannel(string(name)) {
 				return nil
 			}
 
-			channels[string(name)] = b.Stats().KeyN
+			channels[string(name)] = b.KeyN()
 			return nil
 		})
 	})
@@ -86,65 +138,3 @@ func (s *tinyQ) ListChannels() (map[string]int, error) {
 
 	return channels, nil
 }
-
-func (s *tinyQ) Pop_Old(channel string, count ...int) ([]string, error) {
-	co := 1
-	if !s.isOpen {
-		err := s.Open()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	if len(count) > 0 {
-		co = count[0]
-	}
-
-	if co > 10 {
-		co = 10 // Limit to a maximum of 10 items
-	}
-
-	var items []string
-	err := s.db.Update(func(tx *bbolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(channel))
-		if err != nil {
-			return errors.New("error creating channel")
-		}
-
-		if bucket == nil {
-			return errors.New("channel not found")
-		}
-
-		c := bucket.Cursor()
-		for i := 0; i < co; i++ {
-			k, v := c.First()
-			if k == nil {
-				break // No more items to pop
-			}
-
-			item := channel + "." + string(k)
-			if len(v) > 0 {
-				item = item + "." + string(v)
-			}
-
-			items = append(items, item)
-
-			err := bucket.Delete(k) // Remove the item after popping
-			if err != nil {
-				return err
-			}
-
-			c.Next() // Move to the next item
-		}
-		return nil
-	})
-
-	if len(items) > 0 {
-		err = s.Inc(bucketStats, "pop."+channel)
-		if err != nil {
-			return items, err
-		}
-	}
-
-	return items, err
-}
@@ -6,6 +6,7 @@ require (
 	github.com/BurntSushi/toml v1.5.0
 	github.com/gdamore/tcell/v2 v2.9.0
 	github.com/go-redis/redis/v8 v8.11.5
+	github.com/google/btree v1.1.3
 	github.com/google/uuid v1.6.0
 	github.com/rivo/tview v0.42.0
 	github.com/sfi2k7/blueweb v0.0.0-20250825011753-14459d37bf38
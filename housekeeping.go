@@ -0,0 +1,62 @@
+package tinyq
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	housekeepingInterval = time.Second
+	// dedupPruneInterval is longer as pruning scans every remembered push.
+	dedupPruneInterval = time.Minute
+	// changelogTrimInterval is longer as counting changes scans the changelog.
+	changelogTrimInterval = time.Minute
+)
+
+// housekeeping runs the periodic maintenance of an open queue until done is closed.
+func (s *tinyQ) housekeeping(done, stopped chan struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(housekeepingInterval)
+	defer ticker.Stop()
+
+	var pruned, trimmed time.Time
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if time.Since(trimmed) >= changelogTrimInterval {
+				if _, err := s.TrimChangelog(); err != nil && err != ErrNoChangelog {
+					fmt.Println("trim changelog:", err)
+				}
+				trimmed = time.Now()
+			}
+
+			// A replica gets the writes of housekeeping from its changelog.
+			if s.replica() {
+				continue
+			}
+
+			if _, err := s.RequeueExpired(); err != nil {
+				fmt.Println("requeue expired:", err)
+			}
+
+			if _, err := s.PromoteDue(); err != nil {
+				fmt.Println("promote scheduled:", err)
+			}
+
+			if _, err := s.ExpireDue(); err != nil {
+				fmt.Println("expire items:", err)
+			}
+
+			if time.Since(pruned) >= dedupPruneInterval {
+				if _, err := s.PruneDedup(); err != nil {
+					fmt.Println("prune dedup:", err)
+				}
+				pruned = time.Now()
+			}
+		}
+	}
+}
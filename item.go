@@ -0,0 +1,140 @@
+package tinyq
+
+import (
+	"errors"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidItem = errors.New("invalid item")
+
+// System headers are set by the queue itself. Any other header is a custom
+// header of the producer and is kept as is.
+const (
+	// HeaderEnqueuedAt is when the item was pushed, in RFC 3339 format.
+	HeaderEnqueuedAt = "enqueued_at"
+	// HeaderProducer is the name of the token the item was pushed with.
+	HeaderProducer = "producer"
+	// HeaderAttempts is how many times the item was delivered.
+	HeaderAttempts = "attempts"
+	// HeaderTraceID follows an item and the items routed from it. A push
+	// without one is given a new trace id.
+	HeaderTraceID = "trace_id"
+)
+
+// NewTraceID returns a random trace id.
+func NewTraceID() string {
+	return uuid.NewString()
+}
+
+// Item is a single queued item. Unlike the "channel.key.payload" strings of
+// the string API, its channel, key and payload may contain dots.
+//
+// Priority, EnqueuedAt, ExpiresAt and Attempts are filled in by the queue
+// when the item is popped and are ignored on push, and so are the
+// enqueued_at and attempts headers.
+type Item struct {
+	Channel    string            `json:"channel"`
+	Key        string            `json:"key"`
+	Payload    []byte            `json:"payload,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Priority   int               `json:"priority,omitempty"`
+	EnqueuedAt time.Time         `json:"enqueued_at,omitzero"`
+	ExpiresAt  time.Time         `json:"expires_at,omitzero"`
+	Attempts   int               `json:"attempts,omitempty"`
+}
+
+// ParseItem converts a "channel.key.payload" string of the string API into an Item.
+func ParseItem(item string) *Item {
+	channel, key, data := Splititem(item)
+
+	it := &Item{Channel: channel, Key: key}
+	if len(data) > 0 {
+		it.Payload = []byte(data)
+	}
+
+	return it
+}
+
+// String formats the item as "channel.key.payload", the format of the string API.
+func (it *Item) String() string {
+	var sb strings.Builder
+	sb.WriteString(it.Channel)
+	sb.WriteByte('.')
+	sb.WriteString(it.Key)
+	if len(it.Payload) > 0 {
+		sb.WriteByte('.')
+		sb.Write(it.Payload)
+	}
+
+	return sb.String()
+}
+
+func (it *Item) validate() error {
+	if it == nil || len(it.Channel) == 0 || len(it.Key) == 0 {
+		return ErrInvalidItem
+	}
+
+	return nil
+}
+
+// traced returns the item itself when it has a trace id, or a copy with a new one.
+func (it *Item) traced() *Item {
+	if len(it.Headers[HeaderTraceID]) > 0 {
+		return it
+	}
+
+	copied := *it
+	copied.Headers = maps.Clone(it.Headers)
+	if copied.Headers == nil {
+		copied.Headers = make(map[string]string)
+	}
+	copied.Headers[HeaderTraceID] = NewTraceID()
+
+	return &copied
+}
+
+// record stores the item with its trace id and custom headers. The
+// enqueued_at and attempts headers are derived when the item is read back.
+func (it *Item) record() *record {
+	headers := maps.Clone(it.traced().Headers)
+	delete(headers, HeaderEnqueuedAt)
+	delete(headers, HeaderAttempts)
+
+	return &record{Key: it.Key, Payload: it.Payload, Headers: headers}
+}
+
+func (r *record) item(channel string) *Item {
+	headers := maps.Clone(r.Headers)
+	if !r.EnqueuedAt.IsZero() {
+		if headers == nil {
+			headers = make(map[string]string)
+		}
+		headers[HeaderEnqueuedAt] = r.EnqueuedAt.Format(time.RFC3339Nano)
+	}
+
+	return &Item{
+		Channel:    channel,
+		Key:        r.Key,
+		Payload:    r.Payload,
+		Headers:    headers,
+		Priority:   r.Priority,
+		EnqueuedAt: r.EnqueuedAt,
+		ExpiresAt:  r.ExpiresAt,
+	}
+}
+
+// delivered sets the delivery count of a popped or peeked item.
+func (it *Item) delivered(attempts int) {
+	it.Attempts = attempts
+	if attempts > 0 {
+		if it.Headers == nil {
+			it.Headers = make(map[string]string)
+		}
+		it.Headers[HeaderAttempts] = strconv.Itoa(attempts)
+	}
+}
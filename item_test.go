@@ -0,0 +1,134 @@
+package tinyq
+
+import "testing"
+
+func TestPushItemRoundTrip(t *testing.T) {
+	q := newtestq(t, nil)
+
+	// Unlike the string API, the parts of an item may contain dots.
+	pushed := &Item{
+		Channel: "jobs.eu",
+		Key:     "a.1",
+		Payload: []byte("one.two"),
+		Headers: map[string]string{"tenant": "acme"},
+	}
+	if err := q.PushItem(pushed, WithPriority(3)); err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := q.PopItems("jobs.eu", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("popped %d items, want 1", len(items))
+	}
+
+	got := items[0]
+	if got.Channel != pushed.Channel || got.Key != pushed.Key || string(got.Payload) != string(pushed.Payload) {
+		t.Fatalf("popped %+v, want %+v", got, pushed)
+	}
+
+	if got.Priority != 3 || got.EnqueuedAt.IsZero() || got.Headers["tenant"] != "acme" {
+		t.Fatalf("popped %+v", got)
+	}
+
+	// The popped item acknowledges like its string form.
+	if err := q.AckItem(got); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPopItemsCount(t *testing.T) {
+	q := newtestq(t, &Options{MaxPopCount: 2})
+
+	for _, key := range []string{"a", "b", "c"} {
+		push(t, q, "jobs", key, key)
+	}
+
+	// A count is capped by MaxPopCount, no count pops a single item.
+	items, err := q.PopItems("jobs", 10)
+	if err != nil || len(items) != 2 {
+		t.Fatalf("popped %d items, want 2: %v", len(items), err)
+	}
+
+	items, err = q.PopItems("jobs", 0)
+	if err != nil || len(items) != 1 || items[0].Key != "c" {
+		t.Fatalf("popped %v: %v", items, err)
+	}
+
+	if items, err := q.PopItems("missing", 1); err != nil || len(items) != 0 {
+		t.Fatalf("popped %v from a missing channel: %v", items, err)
+	}
+
+	if n := count(t, q, "jobs"); n != 0 {
+		t.Fatalf("jobs holds %d items", n)
+	}
+}
+
+func TestSystemHeaders(t *testing.T) {
+	q := newtestq(t, nil)
+
+	// enqueued_at and attempts are the queue's own, the producer is kept as
+	// stamped by the server.
+	pushed := &Item{Channel: "jobs", Key: "a", Headers: map[string]string{
+		HeaderProducer:   "billing",
+		HeaderAttempts:   "7",
+		HeaderEnqueuedAt: "yesterday",
+	}}
+	if err := q.PushItem(pushed); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := q.PopItems("jobs", 1)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("popped %v: %v", first, err)
+	}
+
+	headers := first[0].Headers
+	trace := headers[HeaderTraceID]
+	if len(trace) == 0 || headers[HeaderProducer] != "billing" || headers[HeaderAttempts] != "1" || headers[HeaderEnqueuedAt] == "yesterday" {
+		t.Fatalf("popped headers %v", headers)
+	}
+
+	// A redelivery counts as another attempt.
+	if err := q.NackItem(first[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := q.PopItems("jobs", 1)
+	if err != nil || len(second) != 1 {
+		t.Fatalf("popped %v: %v", second, err)
+	}
+
+	if second[0].Attempts != 2 || second[0].Headers[HeaderAttempts] != "2" || second[0].Headers[HeaderTraceID] != trace {
+		t.Fatalf("redelivered %+v", second[0])
+	}
+
+	// A worker routes the next step with the headers of the item it
+	// handled, as WebWorkerContext.RouteTo does, so the trace id follows.
+	routed := ParseItem("mail.a.sent")
+	routed.Headers = second[0].Headers
+	if err := q.PushItem(routed); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.AckItem(second[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := q.PopItems("mail", 1)
+	if err != nil || len(next) != 1 {
+		t.Fatalf("popped %v: %v", next, err)
+	}
+
+	if next[0].Headers[HeaderTraceID] != trace || next[0].Headers[HeaderAttempts] != "1" {
+		t.Fatalf("routed headers %v, want trace id %s and a first attempt", next[0].Headers, trace)
+	}
+
+	// Only the producer that pushed an item is recorded.
+	push(t, q, "jobs", "b", "")
+	if items, _ := q.PopItems("jobs", 1); len(items) != 1 || len(items[0].Headers[HeaderProducer]) > 0 {
+		t.Fatalf("popped %v", items)
+	}
+}
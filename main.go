@@ -1,5 +1,11 @@
 package tinyq
 
+import (
+	"context"
+	"io"
+	"time"
+)
+
 const (
 	Stringreverse = iota + 1
 	StringBase64
@@ -9,10 +15,42 @@ const (
 )
 
 type TinyQ interface {
-	Push(item string) error
+	Push(item string, opts ...PushOption) error
+	PushItem(item *Item, opts ...PushOption) error
+	PushBatch(items []*Item, opts ...PushOption) (int, error)
+	PushAt(item string, at time.Time, opts ...PushOption) error
+	PushItemAt(item *Item, at time.Time, opts ...PushOption) error
+	PushAfter(item string, delay time.Duration, opts ...PushOption) error
+	PromoteDue() (int, error)
+	Scheduled() (map[string]int, error)
+	SetChannelTTL(channel string, ttl time.Duration) error
+	ChannelTTL(channel string) (time.Duration, error)
+	SetChannelConfig(channel string, cc *ChannelConfig) error
+	ChannelConfig(channel string) (*ChannelConfig, error)
+	ExpireDue() (int, error)
+	PruneDedup() (int, error)
 	Pop(channel string, count ...int) ([]string, error)
+	PopItems(channel string, count int) ([]*Item, error)
+	PopWait(ctx context.Context, channel string, count int, timeout time.Duration) ([]*Item, error)
+	Ack(item string) error
+	AckItem(item *Item) error
+	Nack(item string, reason ...string) error
+	NackItem(item *Item, reason ...string) error
+	RequeueExpired() (int, error)
+	InFlight(channel string) (int, error)
+	DeadLetters(channel string) ([]*DeadLetter, error)
+	DeadLetter(channel, key string) (*DeadLetter, error)
+	RequeueDeadLetters(channel string, keys ...string) (int, error)
+	PurgeDeadLetters(channel string, keys ...string) (int, error)
 	ListAllKeys(channel string) ([]string, error)
+	Peek(channel, cursor string, limit int) (*Page, error)
 	RemoveItem(item string) error
+	MoveItems(src, dst string, filter MoveFilter, limit int) (int, error)
+	BindTopic(topic, channel string) error
+	UnbindTopic(topic, channel string) error
+	TopicBindings(topic string) ([]string, error)
+	Topics() (map[string][]string, error)
+	Publish(item *Item, opts ...PushOption) (int, error)
 	ListChannels() (map[string]int, error)
 	PauseChannel(channel string) error
 	IsChannelPaused(channel string) (bool, error)
@@ -21,6 +59,14 @@ type TinyQ interface {
 	DeleteChannel(channel string) error
 	Count(channel string) (int, error)
 	Stats(appname string) ([]*ChannelStats, error)
+	Export(w io.Writer, channels ...string) (int, error)
+	Import(r io.Reader, opt ImportOptions) (*ImportResult, error)
+	Backup(w io.Writer) (int64, error)
+	ChangelogPosition() (*ChangelogPosition, error)
+	Changes(ctx context.Context, after uint64, limit int, wait time.Duration) (*ChangeSet, error)
+	ApplyChanges(set *ChangeSet) error
+	TrimChangelog() (int, error)
+	Promote() error
 	Close() error
 	Open() error
 	Get(bucket, key string) (string, error)
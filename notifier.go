@@ -0,0 +1,82 @@
+package tinyq
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// notifier wakes up blocked pops when items are written to their channel.
+type notifier struct {
+	lock    sync.Mutex
+	waiters map[string]chan struct{}
+}
+
+// wait returns a channel that is closed the next time channel is notified.
+func (n *notifier) wait(channel string) <-chan struct{} {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.waiters == nil {
+		n.waiters = make(map[string]chan struct{})
+	}
+
+	wake, ok := n.waiters[channel]
+	if !ok {
+		wake = make(chan struct{})
+		n.waiters[channel] = wake
+	}
+
+	return wake
+}
+
+func (n *notifier) notify(channel string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if wake, ok := n.waiters[channel]; ok {
+		close(wake)
+		delete(n.waiters, channel)
+	}
+}
+
+// signal notifies the waiters of channel once tx is committed.
+func (s *tinyQ) signal(tx Tx, channel string) {
+	tx.OnCommit(func() {
+		s.notifier.notify(channel)
+	})
+}
+
+// PopWait pops up to count items of a channel like PopItems, but when the
+// channel is empty it blocks until an item arrives, the timeout fires or ctx
+// is done. A timeout of zero waits for as long as ctx allows. It returns no
+// items and no error when the timeout fires.
+func (s *tinyQ) PopWait(ctx context.Context, channel string, count int, timeout time.Duration) ([]*Item, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	done := s.closing()
+	for {
+		// Register before popping, so a push right after an empty pop is not missed.
+		wake := s.notifier.wait(channel)
+
+		items, err := s.PopItems(channel, count)
+		if err != nil || len(items) > 0 {
+			return items, err
+		}
+
+		select {
+		case <-wake:
+		case <-expired:
+			return items, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-done:
+			return items, nil
+		}
+	}
+}
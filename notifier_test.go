@@ -0,0 +1,61 @@
+package tinyq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPopWaitWakesOnPush(t *testing.T) {
+	q := newtestq(t, nil)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		push(t, q, "jobs", "a", "one")
+	}()
+
+	items, err := q.PopWait(context.Background(), "jobs", 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 1 || items[0].Key != "a" {
+		t.Fatalf("popped %v", items)
+	}
+}
+
+// TestPopWaitClose ends parked pops when the queue is closed, as a compaction
+// or a restore does while pops wait.
+func TestPopWaitClose(t *testing.T) {
+	q := NewMemoryTinyQ(&Options{Appname: "test"})
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.PopWait(context.Background(), "jobs", 1, 10*time.Second)
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	returned := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("parked pops still waiting after close")
+	}
+}
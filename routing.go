@@ -0,0 +1,86 @@
+package tinyq
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"path"
+)
+
+// RouteRule sends items pushed to a channel matching Pattern to its Targets
+// instead. Rules are stored and evaluated by the server.
+type RouteRule struct {
+	ID string `json:"id"`
+	// Pattern is a glob such as "img.*" matched against the channel of the
+	// pushed item. An empty pattern matches every channel.
+	Pattern string `json:"pattern,omitempty"`
+	// Field and Value restrict the rule to items whose payload has the field
+	// set to the value, such as priority=high.
+	Field   string   `json:"field,omitempty"`
+	Value   string   `json:"value,omitempty"`
+	Targets []string `json:"targets"`
+	// Keep delivers the item to the pushed channel as well.
+	Keep bool `json:"keep,omitempty"`
+}
+
+func (r *RouteRule) Validate() error {
+	if len(r.ID) == 0 {
+		return errors.New("route id is missing")
+	}
+
+	if len(r.Targets) == 0 {
+		return errors.New("route has no targets")
+	}
+
+	if len(r.Pattern) == 0 && len(r.Field) == 0 {
+		return errors.New("route needs a pattern or a field")
+	}
+
+	if _, err := path.Match(r.Pattern, ""); err != nil {
+		return errors.New("invalid route pattern")
+	}
+
+	for _, target := range r.Targets {
+		if len(target) == 0 || isInternalChannel(target) {
+			return errors.New("invalid route target")
+		}
+	}
+
+	return nil
+}
+
+// Match reports whether the rule applies to an item pushed to its channel.
+func (r *RouteRule) Match(item *Item) bool {
+	if len(r.Pattern) > 0 {
+		if ok, _ := path.Match(r.Pattern, item.Channel); !ok {
+			return false
+		}
+	}
+
+	if len(r.Field) > 0 {
+		return PayloadFields(item.Payload)[r.Field] == r.Value
+	}
+
+	return true
+}
+
+// PayloadFields decodes a payload made of key/value pairs: either a JSON
+// object, or the base64 encoded JSON object the web client sends as worker
+// data. Other payloads have no fields.
+func PayloadFields(payload []byte) map[string]string {
+	var fields map[string]string
+	if json.Unmarshal(payload, &fields) == nil {
+		return fields
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return nil
+	}
+
+	if json.Unmarshal(decoded, &fields) != nil {
+		return nil
+	}
+
+	return fields
+}
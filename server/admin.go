@@ -1,20 +1,35 @@
 package server
 
+import "github.com/sfi2k7/tinyq"
+
 type admin struct {
 	qm *queuemanager
 }
 
+// tokennames maps the tokens of an app back to their names.
+func tokennames(app string) string {
+	return app + ":names"
+}
+
 func (a *admin) SetToken(app, tokentype, token string) error {
 	q, err := a.qm.Get("admin")
 	if err != nil {
 		return err
 	}
 
+	if previous, _ := q.Get(app, tokentype); len(previous) > 0 {
+		q.Delete(tokennames(app), previous)
+	}
+
 	if len(token) == 0 {
-		q.Delete(app, tokentype)
+		return q.Delete(app, tokentype)
 	}
 
-	return q.Set(app, tokentype, token)
+	if err := q.Set(app, tokentype, token); err != nil {
+		return err
+	}
+
+	return q.Set(tokennames(app), token, tokentype)
 }
 
 func (a *admin) GetToken(app, tokentype string) (string, error) {
@@ -26,6 +41,22 @@ func (a *admin) GetToken(app, tokentype string) (string, error) {
 	return q.Get(app, tokentype)
 }
 
+// TokenName returns the name a token of the app was set with, or an empty
+// string for an unknown token.
+func (a *admin) TokenName(app, token string) (string, error) {
+	q, err := a.qm.Get("admin")
+	if err != nil {
+		return "", err
+	}
+
+	name, err := q.Get(tokennames(app), token)
+	if err == tinyq.ErrBucketNotFound {
+		return "", nil
+	}
+
+	return name, err
+}
+
 func newadmin(qm *queuemanager) *admin {
 	return &admin{
 		qm: qm,
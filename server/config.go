@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/sfi2k7/tinyq"
+)
+
+// WithConfig applies a configuration file loaded by tinyq.LoadConfig. Its
+// port and data directory replace the ones of earlier options, its logging
+// turns logging on, its app options apply to the apps they name, and its
+// tokens and channel limits are stored when the server starts.
+func WithConfig(c *tinyq.Config) Option {
+	return func(s *queueServer) {
+		if c.Port > 0 {
+			s.port = c.Port
+		}
+
+		if len(c.DataDir) > 0 {
+			s.qm.options.Rootpath = c.DataDir
+		}
+
+		s.logging = s.logging || c.Logging
+
+		c.Defaults.Apply(&s.qm.options)
+		for _, name := range c.AppNames() {
+			app := c.Apps[name]
+			s.qm.appoptions = append(s.qm.appoptions, appoptions{pattern: name, apply: app.Apply})
+		}
+
+		if len(c.Replication.Follow) > 0 {
+			WithFollower(c.Replication.Follow)(s)
+		} else if c.Replication.Changelog {
+			WithChangelog()(s)
+		}
+
+		s.config = c
+	}
+}
+
+// applyconfig stores the tokens and channel limits of the configuration, so
+// they replace the ones set through the API since the last start.
+func (s *queueServer) applyconfig() error {
+	// A follower gets them from its leader.
+	if s.config == nil || s.repl != nil {
+		return nil
+	}
+
+	for _, name := range s.config.AppNames() {
+		app := s.config.Apps[name]
+		if len(app.Tokens) == 0 && len(app.Channels) == 0 {
+			continue
+		}
+
+		for tokenname, token := range app.Tokens {
+			if err := s.admin.SetToken(name, tokenname, token); err != nil {
+				return fmt.Errorf("app %s: token %s: %w", name, tokenname, err)
+			}
+		}
+
+		q, err := s.qm.Get(name)
+		if err != nil {
+			return fmt.Errorf("app %s: %w", name, err)
+		}
+
+		if err := app.ApplyChannels(q); err != nil {
+			return fmt.Errorf("app %s: %w", name, err)
+		}
+
+		for channel, limits := range app.Channels {
+			if limits.Rate > 0 {
+				if err := s.sm.limiter.SetLimit(name, channel, limits.Rate, limits.Burst); err != nil {
+					return fmt.Errorf("app %s: channel %s: %w", name, channel, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -1,13 +1,20 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/sfi2k7/tinyq"
+	"github.com/sfi2k7/tinyq/client"
 )
 
 func channels_delete_endpoint(ctx *queuecontext) {
@@ -30,6 +37,7 @@ func channels_delete_endpoint(ctx *queuecontext) {
 		return
 	}
 
+	ctx.sm.limiter.Forget(ctx.Appname, channel)
 	ctx.sm.AddStat(ctx.Appname, "delete_channel", channel)
 
 	ctx.sendOk("ok")
@@ -116,9 +124,10 @@ func channels_clear_endpoint(ctx *queuecontext) {
 }
 
 func databases_endpoint(ctx *queuecontext) {
-	dirs, err := os.ReadDir("ctx.q.Rootpath")
+	dirs, err := os.ReadDir(ctx.qm.options.Root())
 	if err != nil {
 		ctx.sendOk(`{"error": "error getting databases"}`)
+		return
 	}
 
 	var dbs []string
@@ -133,22 +142,341 @@ func databases_endpoint(ctx *queuecontext) {
 	ctx.Json(dbs)
 }
 
+// queryitem reads the item of a request: a JSON item posted as the body, a
+// "channel.key.payload" item parameter, or separate channel, key and payload
+// parameters whose values may contain dots.
+func queryitem(ctx *queuecontext) (*tinyq.Item, error) {
+	if ctx.Method() == http.MethodPost {
+		var item tinyq.Item
+		if err := ctx.ParseBody(&item); err != nil {
+			return nil, errors.New("invalid item")
+		}
+		return &item, nil
+	}
+
+	if item := ctx.Query("item"); len(item) > 0 {
+		return tinyq.ParseItem(item), nil
+	}
+
+	if len(ctx.Query("channel")) == 0 || len(ctx.Query("key")) == 0 {
+		return nil, errors.New("item is missing")
+	}
+
+	item := &tinyq.Item{Channel: ctx.Query("channel"), Key: ctx.Query("key")}
+	if payload := ctx.Query("payload"); len(payload) > 0 {
+		item.Payload = []byte(payload)
+	}
+
+	return item, nil
+}
+
+// stampproducer sets the producer header of pushed items to the name of the
+// request's token. A producer claimed by the client itself is dropped.
+func stampproducer(ctx *queuecontext, items ...*tinyq.Item) error {
+	var producer string
+	if len(ctx.token) > 0 {
+		name, err := ctx.admin.TokenName(ctx.Appname, ctx.token)
+		if err != nil {
+			return err
+		}
+		producer = name
+	}
+
+	for _, item := range items {
+		if len(producer) == 0 {
+			delete(item.Headers, tinyq.HeaderProducer)
+			continue
+		}
+
+		if item.Headers == nil {
+			item.Headers = make(map[string]string)
+		}
+		item.Headers[tinyq.HeaderProducer] = producer
+	}
+
+	return nil
+}
+
 func push_endpoint(ctx *queuecontext) {
-	item := ctx.Query("item")
-	if len(item) == 0 {
-		ctx.sendOk("error", errors.New("item is missing"))
+	item, err := queryitem(ctx)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	at, err := pushdue(ctx)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	opts, err := pushoptions(ctx)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	if err := stampproducer(ctx, item); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	// Routing rules may send the item to other channels than its own.
+	items, err := routeitems(ctx.q, []*tinyq.Item{item})
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	// The copies of a routed item are written together and deduplicated
+	// as the item itself.
+	if len(items) != 1 || items[0].Channel != item.Channel {
+		channels := make([]string, 0, len(items))
+		for _, routed := range items {
+			channels = append(channels, routed.Channel)
+		}
+		opts = append(opts, tinyq.WithChannels(channels...))
+	}
+
+	stat, status := "push", "ok"
+	if !at.IsZero() {
+		stat, status = "schedule", "scheduled"
+		err = ctx.q.PushItemAt(item, at, opts...)
+	} else {
+		err = ctx.q.PushItem(item, opts...)
+	}
+
+	if err != nil {
+		sendpusherror(ctx, err)
+		return
+	}
+
+	for _, routed := range items {
+		ctx.sm.AddStat(ctx.Appname, stat, routed.Channel)
+	}
+	ctx.sendOk(status)
+}
+
+// sendpusherror responds to a failed push. Duplicates and full channels are
+// reported with statuses of their own.
+func sendpusherror(ctx *queuecontext, err error) {
+	switch err {
+	case tinyq.ErrDuplicate:
+		ctx.sendOk("duplicate")
+	case tinyq.ErrChannelFull:
+		ctx.sendOk("full")
+	default:
+		ctx.sendOk("error", err)
+	}
+}
+
+// pushoptions reads the optional priority, ttl and idempotency_key parameters of a push.
+func pushoptions(ctx *queuecontext) ([]tinyq.PushOption, error) {
+	var opts []tinyq.PushOption
+	if priority := ctx.Query("priority"); len(priority) > 0 {
+		p, err := strconv.Atoi(priority)
+		if err != nil || p < tinyq.MinPriority || p > tinyq.MaxPriority {
+			return nil, errors.New("invalid priority")
+		}
+
+		opts = append(opts, tinyq.WithPriority(p))
+	}
+
+	if ttl := ctx.Query("ttl"); len(ttl) > 0 {
+		d, err := parseduration(ttl)
+		if err != nil || d <= 0 {
+			return nil, errors.New("invalid ttl")
+		}
+
+		opts = append(opts, tinyq.WithTTL(d))
+	}
+
+	if key := ctx.Query("idempotency_key"); len(key) > 0 {
+		opts = append(opts, tinyq.WithIdempotencyKey(key))
+	}
+
+	return opts, nil
+}
+
+// push_batch_endpoint pushes a JSON array of "channel.key.payload" strings
+// and/or item objects in a single transaction and responds with the number
+// of items enqueued.
+func push_batch_endpoint(ctx *queuecontext) {
+	var raw []json.RawMessage
+	if err := ctx.ParseBody(&raw); err != nil {
+		ctx.sendOk("error", errors.New("body must be a JSON array"))
+		return
+	}
+
+	items := make([]*tinyq.Item, 0, len(raw))
+	for _, entry := range raw {
+		if bytes.HasPrefix(bytes.TrimSpace(entry), []byte(`"`)) {
+			var item string
+			if err := json.Unmarshal(entry, &item); err != nil {
+				ctx.sendOk("error", err)
+				return
+			}
+
+			items = append(items, tinyq.ParseItem(item))
+			continue
+		}
+
+		var item tinyq.Item
+		if err := json.Unmarshal(entry, &item); err != nil {
+			ctx.sendOk("error", err)
+			return
+		}
+
+		items = append(items, &item)
+	}
+
+	if err := stampproducer(ctx, items...); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	opts, err := pushoptions(ctx)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	if items, err = routeitems(ctx.q, items); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	pushed, err := ctx.q.PushBatch(items, opts...)
+	if err != nil {
+		sendpusherror(ctx, err)
+		return
+	}
+
+	channels := make(map[string]bool)
+	for _, item := range items {
+		if !channels[item.Channel] {
+			channels[item.Channel] = true
+			ctx.sm.AddStat(ctx.Appname, "push_batch", item.Channel)
+		}
+	}
+
+	ctx.sendOk(strconv.Itoa(pushed))
+}
+
+func topics_endpoint(ctx *queuecontext) {
+	topics, err := ctx.q.Topics()
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.Json(topics)
+}
+
+func topics_bind_endpoint(ctx *queuecontext) {
+	topic, channel := ctx.Query("topic"), ctx.Query("channel")
+	if err := ctx.q.BindTopic(topic, channel); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "bind_topic", channel)
+	ctx.sendOk("ok")
+}
+
+func topics_unbind_endpoint(ctx *queuecontext) {
+	topic, channel := ctx.Query("topic"), ctx.Query("channel")
+	if topic == "" || channel == "" {
+		ctx.sendOk("error", errors.New("topic and channel are required"))
 		return
 	}
 
-	if err := ctx.q.Push(item); err != nil {
-		ctx.sendOk("ok")
+	if err := ctx.q.UnbindTopic(topic, channel); err != nil {
+		ctx.sendOk("error", err)
 		return
 	}
 
-	ctx.sm.AddStat(ctx.Appname, "push", item)
+	ctx.sm.AddStat(ctx.Appname, "unbind_topic", channel)
 	ctx.sendOk("ok")
 }
 
+// topics_publish_endpoint copies an item into every channel bound to its
+// topic. The item is read like a push, with the topic as its channel, and
+// the response is the number of channels written to.
+func topics_publish_endpoint(ctx *queuecontext) {
+	item, err := queryitem(ctx)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	opts, err := pushoptions(ctx)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	if err := stampproducer(ctx, item); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	published, err := ctx.q.Publish(item, opts...)
+	if err != nil {
+		sendpusherror(ctx, err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "publish", item.Channel)
+	ctx.sendOk(strconv.Itoa(published))
+}
+
+// parseduration accepts a Go duration such as "1m30s" or a number of seconds.
+func parseduration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err == nil {
+		return d, nil
+	}
+
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// pushdue reads the optional delay (duration or seconds) or at (RFC3339 or
+// unix seconds) parameters of a push. A zero time means push right away.
+func pushdue(ctx *queuecontext) (time.Time, error) {
+	if delay := ctx.Query("delay"); len(delay) > 0 {
+		d, err := parseduration(delay)
+		if err != nil {
+			return time.Time{}, errors.New("invalid delay")
+		}
+
+		return time.Now().Add(d), nil
+	}
+
+	if at := ctx.Query("at"); len(at) > 0 {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			unix, uerr := strconv.ParseInt(at, 10, 64)
+			if uerr != nil {
+				return time.Time{}, errors.New("invalid at")
+			}
+			t = time.Unix(unix, 0)
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, nil
+}
+
+// maxPopWait caps how long a pop request may be parked.
+const maxPopWait = 5 * time.Minute
+
 func pop_endpoint(ctx *queuecontext) {
 	channel := ctx.Query("channel")
 	count, _ := ctx.QueryInt("count")
@@ -171,13 +499,66 @@ func pop_endpoint(ctx *queuecontext) {
 		return
 	}
 
-	items, err := ctx.q.Pop(channel, count)
+	// A rate limited channel grants at most as many pops as it has tokens.
+	wanted := max(count, 1)
+	granted, retry, err := ctx.sm.limiter.Take(ctx.Appname, channel, wanted)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	if granted == 0 {
+		ctx.Json(&throttledresponse{Message: "throttled", RetryAfter: retry.String()})
+		return
+	}
+
+	if granted < wanted {
+		count = granted
+	}
+
+	var timeout time.Duration
+	if wait := ctx.Query("wait"); len(wait) > 0 {
+		timeout, err = parseduration(wait)
+		if err != nil || timeout <= 0 {
+			ctx.sendOk("error", errors.New("invalid wait"))
+			return
+		}
+
+		timeout = min(timeout, maxPopWait)
+	}
+
+	items, err := ctx.q.PopItems(channel, count)
+	ctx.sm.limiter.Refund(ctx.Appname, channel, granted-len(items))
+
+	if err == nil && len(items) == 0 && timeout > 0 {
+		// Park the request until an item arrives or the wait is over. Its
+		// tokens are back in the bucket meanwhile, so it does not throttle
+		// the other pops of the channel; what it pops is charged on return.
+		// A parked pop must not hold up a swap of the app's file either. The
+		// swap closes the queue, which ends the wait with nothing popped.
+		q := ctx.q
+		ctx.release()
+		items, err = q.PopWait(ctx.Request.Context(), channel, count, timeout)
+		ctx.sm.limiter.Charge(ctx.Appname, channel, len(items))
+	}
+
 	if err != nil {
 		fmt.Println(err)
 		ctx.sendOk("error", err)
 		return
 	}
 
+	// The json format returns the popped items as an array, an empty one
+	// when there is nothing to pop.
+	if ctx.Query("format") == "json" {
+		if len(items) > 0 {
+			ctx.sm.AddStat(ctx.Appname, "pop", channel)
+		}
+
+		ctx.Json(items)
+		return
+	}
+
 	if len(items) == 0 {
 		ctx.sendOk("empty")
 		return
@@ -185,7 +566,128 @@ func pop_endpoint(ctx *queuecontext) {
 
 	ctx.sm.AddStat(ctx.Appname, "pop", channel)
 
-	ctx.sendOk(items[0])
+	// With a count the popped items are returned as an array of strings.
+	if wanted > 1 {
+		popped := make([]string, 0, len(items))
+		for _, item := range items {
+			popped = append(popped, item.String())
+		}
+
+		ctx.Json(popped)
+		return
+	}
+
+	ctx.sendOk(items[0].String())
+}
+
+func ack_endpoint(ctx *queuecontext) {
+	item, err := queryitem(ctx)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	if err := ctx.q.AckItem(item); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "ack", item.Channel)
+	ctx.sendOk("ok")
+}
+
+func nack_endpoint(ctx *queuecontext) {
+	item, err := queryitem(ctx)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	if err := ctx.q.NackItem(item, ctx.Query("error")); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "nack", item.Channel)
+	ctx.sendOk("ok")
+}
+
+func deadletters_endpoint(ctx *queuecontext) {
+	channel := ctx.Query("channel")
+	if channel == "" {
+		ctx.sendOk("error", errors.New("channel is missing"))
+		return
+	}
+
+	var items []*tinyq.DeadLetter
+	if key := ctx.Query("key"); len(key) > 0 {
+		item, err := ctx.q.DeadLetter(channel, key)
+		if err != nil && err != tinyq.ErrNotFound {
+			ctx.sendOk("error", err)
+			return
+		}
+
+		if item != nil {
+			items = append(items, item)
+		}
+	} else {
+		var err error
+		if items, err = ctx.q.DeadLetters(channel); err != nil {
+			ctx.sendOk("error", err)
+			return
+		}
+	}
+
+	if items == nil {
+		items = []*tinyq.DeadLetter{}
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "deadletter_list", channel)
+	ctx.Json(items)
+}
+
+func deadletters_requeue_endpoint(ctx *queuecontext) {
+	channel := ctx.Query("channel")
+	if channel == "" {
+		ctx.sendOk("error", errors.New("channel is missing"))
+		return
+	}
+
+	var keys []string
+	if key := ctx.Query("key"); len(key) > 0 {
+		keys = append(keys, key)
+	}
+
+	count, err := ctx.q.RequeueDeadLetters(channel, keys...)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "deadletter_requeue", channel)
+	ctx.sendOk(strconv.Itoa(count))
+}
+
+func deadletters_purge_endpoint(ctx *queuecontext) {
+	channel := ctx.Query("channel")
+	if channel == "" {
+		ctx.sendOk("error", errors.New("channel is missing"))
+		return
+	}
+
+	var keys []string
+	if key := ctx.Query("key"); len(key) > 0 {
+		keys = append(keys, key)
+	}
+
+	count, err := ctx.q.PurgeDeadLetters(channel, keys...)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "deadletter_purge", channel)
+	ctx.sendOk(strconv.Itoa(count))
 }
 
 func stats_endpoint(ctx *queuecontext) {
@@ -255,6 +757,333 @@ func channels_resume_endpoint(ctx *queuecontext) {
 	ctx.sendOk("unpaused")
 }
 
+func channels_ttl_endpoint(ctx *queuecontext) {
+	channel := ctx.Query("channel")
+	if channel == "" {
+		ctx.sendOk("error", errors.New("channel is missing"))
+		return
+	}
+
+	ttl := ctx.Query("ttl")
+	if len(ttl) == 0 {
+		current, err := ctx.q.ChannelTTL(channel)
+		if err != nil {
+			ctx.sendOk("error", err)
+			return
+		}
+
+		ctx.sendOk(current.String())
+		return
+	}
+
+	d, err := parseduration(ttl)
+	if err != nil || d < 0 {
+		ctx.sendOk("error", errors.New("invalid ttl"))
+		return
+	}
+
+	if err := ctx.q.SetChannelTTL(channel, d); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "channel_ttl", channel)
+	ctx.sendOk("ok")
+}
+
+// channels_config_endpoint returns the capacity limits of a channel, or sets
+// them when max_length, max_bytes or overflow is passed.
+func channels_config_endpoint(ctx *queuecontext) {
+	channel := ctx.Query("channel")
+	if channel == "" {
+		ctx.sendOk("error", errors.New("channel is missing"))
+		return
+	}
+
+	maxlength, maxbytes, overflow := ctx.Query("max_length"), ctx.Query("max_bytes"), ctx.Query("overflow")
+	if len(maxlength) == 0 && len(maxbytes) == 0 && len(overflow) == 0 {
+		cc, err := ctx.q.ChannelConfig(channel)
+		if err != nil {
+			ctx.sendOk("error", err)
+			return
+		}
+
+		ctx.Json(cc)
+		return
+	}
+
+	cc := &tinyq.ChannelConfig{Overflow: tinyq.OverflowPolicy(overflow)}
+	if len(maxlength) > 0 {
+		n, err := strconv.Atoi(maxlength)
+		if err != nil {
+			ctx.sendOk("error", errors.New("invalid max_length"))
+			return
+		}
+		cc.MaxLength = n
+	}
+
+	if len(maxbytes) > 0 {
+		n, err := strconv.ParseInt(maxbytes, 10, 64)
+		if err != nil {
+			ctx.sendOk("error", errors.New("invalid max_bytes"))
+			return
+		}
+		cc.MaxBytes = n
+	}
+
+	if err := ctx.q.SetChannelConfig(channel, cc); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "channel_config", channel)
+	ctx.sendOk("ok")
+}
+
+// channels_ratelimit_endpoint returns the consume rate limit of a channel, or
+// sets it when rate (pops per second) or burst is passed. A zero rate removes
+// the limit.
+func channels_ratelimit_endpoint(ctx *queuecontext) {
+	channel := ctx.Query("channel")
+	if channel == "" {
+		ctx.sendOk("error", errors.New("channel is missing"))
+		return
+	}
+
+	rate, burst := ctx.Query("rate"), ctx.Query("burst")
+	if len(rate) == 0 && len(burst) == 0 {
+		r, b, err := ctx.sm.limiter.Limit(ctx.Appname, channel)
+		if err != nil {
+			ctx.sendOk("error", err)
+			return
+		}
+
+		ctx.Json(map[string]any{"rate": r, "burst": b})
+		return
+	}
+
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil || r < 0 {
+		ctx.sendOk("error", errors.New("invalid rate"))
+		return
+	}
+
+	var b int
+	if len(burst) > 0 {
+		if b, err = strconv.Atoi(burst); err != nil || b < 0 {
+			ctx.sendOk("error", errors.New("invalid burst"))
+			return
+		}
+	}
+
+	if err := ctx.sm.limiter.SetLimit(ctx.Appname, channel, r, b); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "channel_ratelimit", channel)
+	ctx.sendOk("ok")
+}
+
+// channels_items_endpoint returns a page of a channel's items without
+// removing them. The cursor of the response continues with the next page.
+func channels_items_endpoint(ctx *queuecontext) {
+	channel := ctx.Query("channel")
+	if channel == "" {
+		ctx.sendOk("error", errors.New("channel is missing"))
+		return
+	}
+
+	limit, _ := ctx.QueryInt("limit")
+	page, err := ctx.q.Peek(channel, ctx.Query("cursor"), limit)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "peek", channel)
+	ctx.Json(page)
+}
+
+// admin_move_endpoint moves the items of channel src matching the optional
+// key prefix and payload substring to channel dst in one transaction.
+func admin_move_endpoint(ctx *queuecontext) {
+	src, dst := ctx.Query("src"), ctx.Query("dst")
+	if src == "" || dst == "" {
+		ctx.sendError(errors.New("src and dst are required"))
+		return
+	}
+
+	for _, channel := range []string{src, dst} {
+		if islocked, err := ctx.sm.IsChannelLocked(ctx.Appname, channel); err != nil {
+			ctx.sendError(err)
+			return
+		} else if islocked {
+			ctx.sendOk("locked")
+			return
+		}
+	}
+
+	var filters []tinyq.MoveFilter
+	if prefix := ctx.Query("prefix"); len(prefix) > 0 {
+		filters = append(filters, tinyq.KeyPrefix(prefix))
+	}
+
+	if contains := ctx.Query("contains"); len(contains) > 0 {
+		filters = append(filters, tinyq.PayloadContains(contains))
+	}
+
+	var filter tinyq.MoveFilter
+	if len(filters) > 0 {
+		filter = func(item *tinyq.Item) bool {
+			for _, f := range filters {
+				if !f(item) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	limit, _ := ctx.QueryInt("limit")
+	moved, err := ctx.q.MoveItems(src, dst, filter, limit)
+	if err != nil {
+		ctx.sendError(err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "move", src)
+	ctx.sendOk(strconv.Itoa(moved))
+}
+
+// admin_token_endpoint names a token of the app. Items pushed with the token
+// carry its name in their producer header. An empty token removes the name.
+func admin_token_endpoint(ctx *queuecontext) {
+	name := ctx.Query("name")
+	if name == "" {
+		ctx.sendError(errors.New("name is missing"))
+		return
+	}
+
+	if err := ctx.admin.SetToken(ctx.Appname, name, ctx.Query("value")); err != nil {
+		ctx.sendError(err)
+		return
+	}
+
+	ctx.sendOk("ok")
+}
+
+// admin_backup_endpoint streams a consistent snapshot of the app's database.
+func admin_backup_endpoint(ctx *queuecontext) {
+	ctx.SetHeader("Content-Type", "application/octet-stream")
+	ctx.SetHeader("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.db"`, ctx.Appname))
+
+	if _, err := ctx.q.Backup(ctx); err != nil {
+		// Nothing is written before the snapshot starts, so a driver that
+		// can't make one still gets a regular error response.
+		if err == tinyq.ErrNotSupported {
+			ctx.ResponseWriter.Header().Del("Content-Type")
+			ctx.ResponseWriter.Header().Del("Content-Disposition")
+			ctx.sendError(err)
+			return
+		}
+
+		fmt.Println("backup failed:", err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "backup", "")
+}
+
+// admin_restore_endpoint replaces the app's database with the snapshot
+// posted as the request body.
+func admin_restore_endpoint(ctx *queuecontext) {
+	defer ctx.Request.Body.Close()
+
+	ctx.release()
+	if err := ctx.qm.Restore(ctx.Appname, ctx.Request.Body); err != nil {
+		ctx.sendError(err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "restore", "")
+	ctx.sendOk("ok")
+}
+
+// admin_compact_endpoint rewrites the app's file without its free pages.
+func admin_compact_endpoint(ctx *queuecontext) {
+	ctx.release()
+	before, after, err := ctx.qm.Compact(ctx.Appname)
+	if err != nil {
+		ctx.sendError(err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "compact", "")
+	ctx.Json(map[string]int64{"before": before, "after": after})
+}
+
+// admin_export_endpoint streams the items of the comma separated channels,
+// or of every channel, as JSON Lines.
+func admin_export_endpoint(ctx *queuecontext) {
+	var channels []string
+	if list := ctx.Query("channels"); len(list) > 0 {
+		channels = strings.Split(list, ",")
+	}
+
+	ctx.SetHeader("Content-Type", "application/x-ndjson")
+	ctx.SetHeader("Trailer", client.ExportErrorTrailer)
+
+	w := &exportwriter{w: ctx}
+	if _, err := ctx.q.Export(w, channels...); err != nil {
+		// An export that failed before its first items went out still gets
+		// a regular error response. Once under way, the error is sent in a
+		// trailer for the client to check after the last item.
+		if !w.started {
+			ctx.ResponseWriter.Header().Del("Trailer")
+			ctx.ResponseWriter.Header().Del("Content-Type")
+			ctx.sendError(err)
+			return
+		}
+
+		fmt.Println("export failed:", err)
+		ctx.ResponseWriter.Header().Set(client.ExportErrorTrailer, err.Error())
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "export", "")
+}
+
+// exportwriter tells whether an export has written anything to the response.
+type exportwriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (w *exportwriter) Write(p []byte) (int, error) {
+	w.started = true
+	return w.w.Write(p)
+}
+
+// admin_import_endpoint pushes the items of the JSON Lines body. conflict
+// is skip, overwrite or rename, and channel imports every item into it.
+func admin_import_endpoint(ctx *queuecontext) {
+	defer ctx.Request.Body.Close()
+
+	result, err := ctx.q.Import(ctx.Request.Body, tinyq.ImportOptions{
+		Conflict: tinyq.ImportConflict(ctx.Query("conflict")),
+		Channel:  ctx.Query("channel"),
+	})
+
+	if err != nil {
+		ctx.sendError(fmt.Errorf("%v, %d items imported before", err, result.Imported+result.Renamed))
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "import", "")
+	ctx.Json(result)
+}
+
 func channels_endpoint(ctx *queuecontext) {
 	channels, err := ctx.q.ListChannels()
 	if err != nil {
@@ -313,3 +1142,68 @@ func crud_endpoint(ctx *queuecontext) {
 
 	ctx.sendOk(res, err)
 }
+
+// replication_changes_endpoint returns the changes of the app's changelog
+// recorded after the "after" change, parking the request like a pop until
+// there is one or the wait is over.
+func replication_changes_endpoint(ctx *queuecontext) {
+	var after uint64
+	if value := ctx.Query("after"); len(value) > 0 {
+		var err error
+		if after, err = strconv.ParseUint(value, 10, 64); err != nil {
+			ctx.sendOk("error", errors.New("invalid after"))
+			return
+		}
+	}
+
+	limit, _ := ctx.QueryInt("limit")
+
+	var wait time.Duration
+	if value := ctx.Query("wait"); len(value) > 0 {
+		var err error
+		if wait, err = parseduration(value); err != nil || wait < 0 {
+			ctx.sendOk("error", errors.New("invalid wait"))
+			return
+		}
+	}
+
+	if wait > maxPopWait {
+		wait = maxPopWait
+	}
+
+	q := ctx.q
+	ctx.release()
+	set, err := q.Changes(ctx.Request.Context(), after, limit, wait)
+	if err == tinyq.ErrChangelogGap {
+		ctx.sendOk("resync", err)
+		return
+	}
+
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.Json(set)
+}
+
+func replication_stats_endpoint(ctx *queuecontext) {
+	ctx.Json(replicationstats(ctx.qm, ctx.repl))
+}
+
+// admin_promote_endpoint turns a follower into a writable leader.
+func admin_promote_endpoint(ctx *queuecontext) {
+	if ctx.repl == nil {
+		ctx.sendError(errors.New("server is not a follower"))
+		return
+	}
+
+	ctx.release()
+	if err := ctx.repl.promote(); err != nil {
+		ctx.sendError(err)
+		return
+	}
+
+	ctx.sm.AddStat(ctx.Appname, "promote", "")
+	ctx.sendOk("ok")
+}
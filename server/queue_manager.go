@@ -2,7 +2,11 @@ package server
 
 import (
 	"errors"
+	"io"
+	"os"
+	"path"
 	"sync"
+	"sync/atomic"
 
 	"github.com/sfi2k7/tinyq"
 )
@@ -10,6 +14,73 @@ import (
 type queuemanager struct {
 	queues sync.Map
 	lock   sync.Mutex
+	// options are used for every queue the manager opens.
+	options tinyq.Options
+	// memory holds the name patterns of the apps kept in memory only.
+	memory []string
+	// appoptions change the options of the apps matching their pattern.
+	appoptions []appoptions
+	// changelog records the writes of the replicated apps, which are opened
+	// as read-only replicas while replica is set.
+	changelog bool
+	replica   atomic.Bool
+	// gates hold a *sync.RWMutex per app. Requests share it, while a swap of
+	// the app's file takes it alone so requests wait for the swap to end.
+	gates sync.Map
+}
+
+type appoptions struct {
+	pattern string
+	apply   func(*tinyq.Options)
+}
+
+func (qm *queuemanager) gate(name string) *sync.RWMutex {
+	gate, _ := qm.gates.LoadOrStore(name, &sync.RWMutex{})
+	return gate.(*sync.RWMutex)
+}
+
+// Acquire returns the queue of an app for the length of a request. The queue
+// is not swapped until release is called.
+func (qm *queuemanager) Acquire(name string) (tinyq.TinyQ, func(), error) {
+	gate := qm.gate(name)
+	gate.RLock()
+
+	q, err := qm.Get(name)
+	if err != nil {
+		gate.RUnlock()
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	return q, func() { once.Do(gate.RUnlock) }, nil
+}
+
+// swap closes an app, runs fn and reopens it, once the requests using the
+// app are done. Requests arriving meanwhile wait for the app to be reopened.
+func (qm *queuemanager) swap(name string, fn func() error) error {
+	if !qm.infile(qm.optionsfor(name)) {
+		return tinyq.ErrNotSupported
+	}
+
+	gate := qm.gate(name)
+	gate.Lock()
+	defer gate.Unlock()
+
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	if qm.hasQueue(name) {
+		if err := qm.Detach(name); err != nil {
+			return err
+		}
+	}
+
+	err := fn()
+	if oerr := qm.open(name); err == nil {
+		err = oerr
+	}
+
+	return err
 }
 
 func (qm *queuemanager) Get(name string) (tinyq.TinyQ, error) {
@@ -44,9 +115,17 @@ func (qm *queuemanager) loadQueue(name string) error {
 	qm.lock.Lock()
 	defer qm.lock.Unlock()
 
-	tq := tinyq.NewTinyQ(&tinyq.Options{
-		Appname: name,
-	})
+	// Another request may have opened it while this one waited.
+	if qm.hasQueue(name) {
+		return nil
+	}
+
+	return qm.open(name)
+}
+
+// open opens a queue. It must be called with the lock held.
+func (qm *queuemanager) open(name string) error {
+	tq := tinyq.NewTinyQ(qm.optionsfor(name))
 
 	err := tq.Open()
 	if err != nil {
@@ -58,6 +137,35 @@ func (qm *queuemanager) loadQueue(name string) error {
 	return nil
 }
 
+// optionsfor returns the options an app is opened with.
+func (qm *queuemanager) optionsfor(name string) *tinyq.Options {
+	opt := qm.options
+	for _, ao := range qm.appoptions {
+		if ok, _ := path.Match(ao.pattern, name); ok {
+			ao.apply(&opt)
+		}
+	}
+
+	opt.Appname = name
+	for _, pattern := range qm.memory {
+		if ok, _ := path.Match(pattern, name); ok {
+			opt.Driver = tinyq.DriverMemory
+		}
+	}
+
+	if qm.changelog && replicated(name) && qm.infile(&opt) {
+		opt.Changelog = true
+		opt.Replica = qm.replica.Load()
+	}
+
+	return &opt
+}
+
+// infile reports whether an app is kept in a file that can be swapped.
+func (qm *queuemanager) infile(opt *tinyq.Options) bool {
+	return len(opt.Driver) == 0 || opt.Driver == tinyq.DriverBolt
+}
+
 func (qm *queuemanager) Detach(name string) error {
 	if !qm.hasQueue(name) {
 		return errors.New("queue not found")
@@ -77,3 +185,35 @@ func (qm *queuemanager) Detach(name string) error {
 
 	return nil
 }
+
+// Restore replaces the database of an app with a snapshot made by Backup.
+// The snapshot is checked before the app is closed, and the app is reopened
+// on the restored file.
+func (qm *queuemanager) Restore(name string, r io.Reader) error {
+	opt := qm.optionsfor(name)
+	if !qm.infile(opt) {
+		return tinyq.ErrNotSupported
+	}
+
+	staged, err := tinyq.StageRestore(opt, r)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(staged)
+
+	return qm.swap(name, func() error {
+		return os.Rename(staged, tinyq.DBPath(opt))
+	})
+}
+
+// Compact shrinks the file of an app and returns its size before and after.
+func (qm *queuemanager) Compact(name string) (int64, int64, error) {
+	var before, after int64
+	err := qm.swap(name, func() error {
+		var err error
+		before, after, err = tinyq.Compact(qm.optionsfor(name))
+		return err
+	})
+
+	return before, after, err
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sfi2k7/tinyq"
+)
+
+// tokenbucket allows rate pops per second with bursts of up to burst pops.
+type tokenbucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newtokenbucket(rate float64, burst int) *tokenbucket {
+	if burst <= 0 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+
+	return &tokenbucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *tokenbucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take grants up to n tokens. When none is available it returns how long
+// until the next one is.
+func (b *tokenbucket) take(n int, now time.Time) (int, time.Duration) {
+	b.refill(now)
+
+	granted := int(math.Min(float64(n), math.Floor(b.tokens)))
+	if granted > 0 {
+		b.tokens -= float64(granted)
+		return granted, 0
+	}
+
+	return 0, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenbucket) refund(n int) {
+	b.tokens = math.Min(b.burst, b.tokens+float64(n))
+}
+
+// charge takes n tokens whether or not they are available. The bucket goes
+// into debt, which holds up the next pops until the rate paid it back.
+func (b *tokenbucket) charge(n int, now time.Time) {
+	b.refill(now)
+	b.tokens -= float64(n)
+}
+
+// ratelimiter keeps the token buckets of every rate limited channel in
+// memory. The limits themselves are kept in the states queue.
+type ratelimiter struct {
+	qm      *queuemanager
+	lock    sync.Mutex
+	buckets map[string]*tokenbucket
+	loaded  map[string]bool
+}
+
+const bucketRateLimits = "__ratelimits__"
+
+func newratelimiter(qm *queuemanager) *ratelimiter {
+	return &ratelimiter{
+		qm:      qm,
+		buckets: make(map[string]*tokenbucket),
+		loaded:  make(map[string]bool),
+	}
+}
+
+func encodelimit(rate float64, burst int) string {
+	return strconv.FormatFloat(rate, 'f', -1, 64) + "/" + strconv.Itoa(burst)
+}
+
+func decodelimit(value string) (float64, int, error) {
+	r, b, _ := strings.Cut(value, "/")
+	rate, err := strconv.ParseFloat(r, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	burst, _ := strconv.Atoi(b)
+	return rate, burst, nil
+}
+
+// SetLimit limits the pops of a channel to rate per second with bursts of
+// up to burst pops. A zero rate removes the limit.
+func (rl *ratelimiter) SetLimit(appname, channel string, rate float64, burst int) error {
+	q, err := rl.qm.Get("states")
+	if err != nil {
+		return err
+	}
+
+	key := appname + ":" + channel
+	if rate <= 0 {
+		err = q.Delete(bucketRateLimits, key)
+	} else {
+		err = q.Set(bucketRateLimits, key, encodelimit(rate, burst))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if rate <= 0 {
+		rl.evict(key)
+		return nil
+	}
+
+	rl.buckets[key] = newtokenbucket(rate, burst)
+	rl.loaded[key] = true
+
+	return nil
+}
+
+// Forget drops what is kept in memory for a deleted channel. Its limit, if
+// any, is loaded again when the channel is popped from.
+func (rl *ratelimiter) Forget(appname, channel string) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	rl.evict(appname + ":" + channel)
+}
+
+// evict must be called with the lock held.
+func (rl *ratelimiter) evict(key string) {
+	delete(rl.buckets, key)
+	delete(rl.loaded, key)
+}
+
+// Limit returns the rate and burst of a channel, zero when it is not limited.
+func (rl *ratelimiter) Limit(appname, channel string) (float64, int, error) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	b, err := rl.bucket(appname + ":" + channel)
+	if err != nil || b == nil {
+		return 0, 0, err
+	}
+
+	return b.rate, int(b.burst), nil
+}
+
+// bucket returns the token bucket of a channel, loading its limit the first
+// time the channel is seen. It must be called with the lock held.
+func (rl *ratelimiter) bucket(key string) (*tokenbucket, error) {
+	if rl.loaded[key] {
+		return rl.buckets[key], nil
+	}
+
+	q, err := rl.qm.Get("states")
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := q.Get(bucketRateLimits, key)
+	if err != nil && err != tinyq.ErrBucketNotFound {
+		return nil, err
+	}
+
+	if len(value) > 0 {
+		rate, burst, err := decodelimit(value)
+		if err != nil {
+			return nil, err
+		}
+		rl.buckets[key] = newtokenbucket(rate, burst)
+	}
+
+	rl.loaded[key] = true
+	return rl.buckets[key], nil
+}
+
+// Take grants up to n pops of a channel. When none is allowed right now it
+// returns how long to wait before retrying.
+func (rl *ratelimiter) Take(appname, channel string, n int) (int, time.Duration, error) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	b, err := rl.bucket(appname + ":" + channel)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if b == nil {
+		return n, 0, nil
+	}
+
+	granted, retry := b.take(n, time.Now())
+	return granted, retry, nil
+}
+
+// Charge takes the tokens of pops that were made without them, such as the
+// pops of a request parked until items arrive.
+func (rl *ratelimiter) Charge(appname, channel string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	b, err := rl.bucket(appname + ":" + channel)
+	if err == nil && b != nil {
+		b.charge(n, time.Now())
+	}
+}
+
+// Refund returns the tokens of pops that found nothing to pop.
+func (rl *ratelimiter) Refund(appname, channel string, n int) {
+	if n <= 0 {
+		return
+	}
+
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if b := rl.buckets[appname+":"+channel]; b != nil {
+		b.refund(n)
+	}
+}
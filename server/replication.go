@@ -0,0 +1,321 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sfi2k7/tinyq"
+	"github.com/sfi2k7/tinyq/client"
+)
+
+const (
+	// replicationWait is how long a follower's request for changes waits
+	// for the leader to record one.
+	replicationWait = 10 * time.Second
+	// replicationRetry is how long a follower waits after a failure.
+	replicationRetry = time.Second
+	// replicationDiscovery is how often a follower looks for new apps.
+	replicationDiscovery = 5 * time.Second
+)
+
+// replicated reports whether an app is replicated. Request counters are
+// kept by each server on its own.
+func replicated(name string) bool {
+	return name != "stats"
+}
+
+// WithChangelog records the writes of every app kept in a file, so follower
+// servers can replicate them.
+func WithChangelog() Option {
+	return func(s *queueServer) {
+		s.qm.changelog = true
+	}
+}
+
+// WithFollower makes the server a follower of the leader server at url. It
+// replicates the apps of the leader into its own files and refuses writes
+// until it is promoted.
+func WithFollower(url string) Option {
+	return func(s *queueServer) {
+		s.qm.changelog = true
+		s.qm.replica.Store(true)
+		s.repl = newreplicator(s.qm, url)
+	}
+}
+
+// replicator tails the changelog of every app of the leader and applies it
+// to the local copy of the app.
+type replicator struct {
+	qm     *queuemanager
+	leader string
+	done   chan struct{}
+
+	// applylock keeps changes from being applied once promoted.
+	applylock sync.Mutex
+	promoted  bool
+
+	lock sync.Mutex
+	apps map[string]*tinyq.AppReplication
+}
+
+func newreplicator(qm *queuemanager, leader string) *replicator {
+	return &replicator{
+		qm:     qm,
+		leader: leader,
+		done:   make(chan struct{}),
+		apps:   make(map[string]*tinyq.AppReplication),
+	}
+}
+
+// sleep waits for d and reports whether the replicator is still running.
+func (r *replicator) sleep(d time.Duration) bool {
+	select {
+	case <-r.done:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// run starts tailing the apps of the leader as they are created, until the
+// server is promoted.
+func (r *replicator) run() {
+	tailing := make(map[string]bool)
+	leader := client.NewWebClient(client.WithUrl(r.leader))
+
+	for {
+		names, err := leader.Databases()
+		if err != nil {
+			fmt.Println("replication: list apps:", err)
+		}
+
+		for _, name := range names {
+			if tailing[name] || !replicated(name) || !r.qm.infile(r.qm.optionsfor(name)) {
+				continue
+			}
+
+			tailing[name] = true
+			go r.tail(name)
+		}
+
+		if !r.sleep(replicationDiscovery) {
+			return
+		}
+	}
+}
+
+func (r *replicator) tail(app string) {
+	leader := client.NewWebClient(client.WithUrl(r.leader), client.WithAppname(app))
+
+	for {
+		err := r.step(leader, app)
+		if err == tinyq.ErrChangelogGap {
+			err = r.resync(leader, app)
+		}
+
+		if err != nil {
+			r.update(app, func(status *tinyq.AppReplication) {
+				status.Error = err.Error()
+			})
+
+			if !r.sleep(replicationRetry) {
+				return
+			}
+
+			continue
+		}
+
+		select {
+		case <-r.done:
+			return
+		default:
+		}
+	}
+}
+
+// step applies the next changes of an app, waiting for the leader to record
+// them. It returns tinyq.ErrChangelogGap when the app must be resynced.
+func (r *replicator) step(leader *client.WebClient, app string) error {
+	pos, err := r.position(app)
+	if err != nil {
+		return err
+	}
+
+	set, err := leader.Changes(pos.Seq, 0, replicationWait)
+	if err != nil {
+		return err
+	}
+
+	if set.ID != pos.ID {
+		return tinyq.ErrChangelogGap
+	}
+
+	r.applylock.Lock()
+	defer r.applylock.Unlock()
+
+	if r.promoted {
+		return nil
+	}
+
+	q, release, err := r.qm.Acquire(app)
+	if err != nil {
+		return err
+	}
+
+	err = q.ApplyChanges(set)
+	release()
+	if err != nil {
+		return err
+	}
+
+	applied, err := r.position(app)
+	if err != nil {
+		return err
+	}
+
+	r.update(app, func(status *tinyq.AppReplication) {
+		status.Applied, status.Head = applied.Seq, set.Head
+		status.Behind, status.LagSeconds = 0, 0
+		if set.Head > applied.Seq {
+			status.Behind = set.Head - applied.Seq
+			status.LagSeconds = set.HeadTime.Sub(applied.Time).Seconds()
+		}
+		status.LastContact = time.Now()
+		status.Error = ""
+	})
+
+	return nil
+}
+
+func (r *replicator) position(app string) (*tinyq.ChangelogPosition, error) {
+	q, release, err := r.qm.Acquire(app)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return q.ChangelogPosition()
+}
+
+// resync replaces the local copy of an app with a backup of the leader's,
+// which holds the changelog the copy then carries on from.
+func (r *replicator) resync(leader *client.WebClient, app string) error {
+	r.applylock.Lock()
+	defer r.applylock.Unlock()
+
+	if r.promoted {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := leader.Backup(pw)
+		pw.CloseWithError(err)
+	}()
+
+	err := r.qm.Restore(app, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		return fmt.Errorf("resync: %w", err)
+	}
+
+	fmt.Println("replication: resynced", app)
+	return nil
+}
+
+func (r *replicator) update(app string, fn func(*tinyq.AppReplication)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	status, ok := r.apps[app]
+	if !ok {
+		status = &tinyq.AppReplication{}
+		r.apps[app] = status
+	}
+
+	fn(status)
+}
+
+// promote stops the replication and makes every app writable.
+func (r *replicator) promote() error {
+	r.applylock.Lock()
+	defer r.applylock.Unlock()
+
+	if r.promoted {
+		return nil
+	}
+
+	r.promoted = true
+	close(r.done)
+
+	return r.qm.promote()
+}
+
+// stats returns the replication of the apps, or nil once promoted.
+func (r *replicator) stats() *tinyq.ReplicationStats {
+	r.applylock.Lock()
+	promoted := r.promoted
+	r.applylock.Unlock()
+
+	if promoted {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stats := &tinyq.ReplicationStats{Role: "follower", Leader: r.leader, Apps: make(map[string]*tinyq.AppReplication)}
+	for app, status := range r.apps {
+		copied := *status
+		stats.Apps[app] = &copied
+	}
+
+	return stats
+}
+
+// promote opens the apps as writable from now on, and promotes the ones
+// already open.
+func (qm *queuemanager) promote() error {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	qm.replica.Store(false)
+
+	var err error
+	qm.queues.Range(func(name, q any) bool {
+		if perr := q.(tinyq.TinyQ).Promote(); perr != nil && perr != tinyq.ErrNoChangelog {
+			err = errors.Join(err, fmt.Errorf("app %s: %w", name, perr))
+		}
+		return true
+	})
+
+	return err
+}
+
+// replicationstats returns the role of the server and the changelog position
+// of its apps.
+func replicationstats(qm *queuemanager, repl *replicator) *tinyq.ReplicationStats {
+	if repl != nil {
+		if stats := repl.stats(); stats != nil {
+			return stats
+		}
+	}
+
+	stats := &tinyq.ReplicationStats{Role: "none", Apps: make(map[string]*tinyq.AppReplication)}
+	if !qm.changelog {
+		return stats
+	}
+
+	stats.Role = "leader"
+	qm.queues.Range(func(name, q any) bool {
+		if pos, err := q.(tinyq.TinyQ).ChangelogPosition(); err == nil {
+			stats.Apps[name.(string)] = &tinyq.AppReplication{Applied: pos.Seq, Head: pos.Seq}
+		}
+		return true
+	})
+
+	return stats
+}
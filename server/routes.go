@@ -0,0 +1,246 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sfi2k7/tinyq"
+)
+
+// Routing rules are kept per app, as a single document in the app's own queue.
+const (
+	bucketRoutes = "internal:routes"
+	keyRoutes    = "rules"
+)
+
+// routeslock serializes changes to the rules of an app.
+var routeslock sync.Mutex
+
+func loadroutes(q tinyq.TinyQ) ([]*tinyq.RouteRule, error) {
+	value, err := q.Get(bucketRoutes, keyRoutes)
+	if err == tinyq.ErrBucketNotFound || (err == nil && len(value) == 0) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*tinyq.RouteRule
+	if err := json.Unmarshal([]byte(value), &rules); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func saveroutes(q tinyq.TinyQ, rules []*tinyq.RouteRule) error {
+	encoded, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	return q.Set(bucketRoutes, keyRoutes, string(encoded))
+}
+
+// routechannels returns the channels an item pushed to its channel is
+// delivered to: the targets of every matching rule, or the channel itself
+// when no rule matches.
+func routechannels(rules []*tinyq.RouteRule, item *tinyq.Item) []string {
+	var channels []string
+	seen := make(map[string]bool)
+	add := func(channel string) {
+		if !seen[channel] {
+			seen[channel] = true
+			channels = append(channels, channel)
+		}
+	}
+
+	var matched, keep bool
+	for _, rule := range rules {
+		if !rule.Match(item) {
+			continue
+		}
+
+		matched = true
+		keep = keep || rule.Keep
+		for _, target := range rule.Targets {
+			add(target)
+		}
+	}
+
+	if !matched {
+		return []string{item.Channel}
+	}
+
+	if keep && !seen[item.Channel] {
+		channels = append([]string{item.Channel}, channels...)
+	}
+
+	return channels
+}
+
+// routeitems replaces every item by its copies for the channels it is routed to.
+func routeitems(q tinyq.TinyQ, items []*tinyq.Item) ([]*tinyq.Item, error) {
+	rules, err := loadroutes(q)
+	if err != nil || len(rules) == 0 {
+		return items, err
+	}
+
+	var routed []*tinyq.Item
+	for _, item := range items {
+		channels := routechannels(rules, item)
+		if len(channels) > 1 && len(item.Headers[tinyq.HeaderTraceID]) == 0 {
+			// The copies of a routed item share its trace id.
+			item.Headers = maps.Clone(item.Headers)
+			if item.Headers == nil {
+				item.Headers = make(map[string]string)
+			}
+			item.Headers[tinyq.HeaderTraceID] = tinyq.NewTraceID()
+		}
+
+		for _, channel := range channels {
+			copied := *item
+			copied.Channel = channel
+			routed = append(routed, &copied)
+		}
+	}
+
+	return routed, nil
+}
+
+func routes_endpoint(ctx *queuecontext) {
+	rules, err := loadroutes(ctx.q)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	if rules == nil {
+		rules = []*tinyq.RouteRule{}
+	}
+
+	ctx.Json(rules)
+}
+
+// routes_set_endpoint adds a rule or replaces the rule with the same id. The
+// rule is posted as JSON, or passed as id, pattern, field, value, targets
+// (comma separated) and keep parameters.
+func routes_set_endpoint(ctx *queuecontext) {
+	var rule tinyq.RouteRule
+	if ctx.Method() == http.MethodPost {
+		if err := ctx.ParseBody(&rule); err != nil {
+			ctx.sendOk("error", errors.New("invalid route"))
+			return
+		}
+	} else {
+		rule = tinyq.RouteRule{
+			ID:      ctx.Query("id"),
+			Pattern: ctx.Query("pattern"),
+			Field:   ctx.Query("field"),
+			Value:   ctx.Query("value"),
+			Keep:    ctx.Query("keep") == "true",
+		}
+
+		if targets := ctx.Query("targets"); len(targets) > 0 {
+			rule.Targets = strings.Split(targets, ",")
+		}
+	}
+
+	if len(rule.ID) == 0 {
+		rule.ID = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	if err := rule.Validate(); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	routeslock.Lock()
+	defer routeslock.Unlock()
+
+	rules, err := loadroutes(ctx.q)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	replaced := false
+	for i, existing := range rules {
+		if existing.ID == rule.ID {
+			rules[i] = &rule
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		rules = append(rules, &rule)
+	}
+
+	if err := saveroutes(ctx.q, rules); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sendOk(rule.ID)
+}
+
+func routes_delete_endpoint(ctx *queuecontext) {
+	id := ctx.Query("id")
+	if id == "" {
+		ctx.sendOk("error", errors.New("id is missing"))
+		return
+	}
+
+	routeslock.Lock()
+	defer routeslock.Unlock()
+
+	rules, err := loadroutes(ctx.q)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	kept := rules[:0]
+	for _, rule := range rules {
+		if rule.ID != id {
+			kept = append(kept, rule)
+		}
+	}
+
+	if len(kept) == len(rules) {
+		ctx.sendOk("error", tinyq.ErrNotFound)
+		return
+	}
+
+	if err := saveroutes(ctx.q, kept); err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.sendOk("ok")
+}
+
+// routes_test_endpoint is a dry run: it responds with the channels a sample
+// item, passed like a push, would be delivered to without pushing it.
+func routes_test_endpoint(ctx *queuecontext) {
+	item, err := queryitem(ctx)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	rules, err := loadroutes(ctx.q)
+	if err != nil {
+		ctx.sendOk("error", err)
+		return
+	}
+
+	ctx.Json(routechannels(rules, item))
+}
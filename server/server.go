@@ -1,15 +1,23 @@
 package server
 
-import "sync"
+import (
+	"sync"
+	"time"
+
+	"github.com/sfi2k7/tinyq"
+)
 
 type queueServer struct {
 	port      int
 	logging   bool
-	rootpath  string
 	isrunning bool
-	qm        *queuemanager
-	sm        *statemanager
-	admin     *admin
+	// config holds the tokens and channel limits applied on Start.
+	config *tinyq.Config
+	qm     *queuemanager
+	sm     *statemanager
+	admin  *admin
+	// repl replicates the leader of a follower server.
+	repl *replicator
 }
 
 type Option func(*queueServer)
@@ -26,9 +34,50 @@ func WithLogging(logging bool) Option {
 	}
 }
 
+// WithRootPath keeps the files of every app in rootpath instead of the
+// package Rootpath.
 func WithRootPath(rootpath string) Option {
 	return func(s *queueServer) {
-		s.rootpath = rootpath
+		s.qm.options.Rootpath = rootpath
+	}
+}
+
+// WithDedupWindow rejects pushes of an item that was already pushed within
+// window as "duplicate".
+func WithDedupWindow(window time.Duration) Option {
+	return func(s *queueServer) {
+		s.qm.options.DedupWindow = window
+	}
+}
+
+// WithMaxPopCount caps how many items a single pop returns.
+func WithMaxPopCount(count int) Option {
+	return func(s *queueServer) {
+		s.qm.options.MaxPopCount = count
+	}
+}
+
+// WithDurability sets when the writes of every app reach the disk. Use
+// WithAppOptions to set it for some apps only.
+func WithDurability(durability tinyq.Durability) Option {
+	return func(s *queueServer) {
+		s.qm.options.Durability = durability
+	}
+}
+
+// WithAppOptions changes the options of the apps whose name matches the glob
+// pattern, after the options shared by every app are applied.
+func WithAppOptions(pattern string, apply func(*tinyq.Options)) Option {
+	return func(s *queueServer) {
+		s.qm.appoptions = append(s.qm.appoptions, appoptions{pattern: pattern, apply: apply})
+	}
+}
+
+// WithMemoryApps keeps the apps whose name matches one of the glob patterns,
+// such as "scratch-*", in memory only. Their items are lost on restart.
+func WithMemoryApps(patterns ...string) Option {
+	return func(s *queueServer) {
+		s.qm.memory = append(s.qm.memory, patterns...)
 	}
 }
 
@@ -42,6 +91,7 @@ func NewQueueServer(options ...Option) *queueServer {
 
 	s.admin = newadmin(s.qm)
 	s.sm.qm = s.qm
+	s.sm.limiter = newratelimiter(s.qm)
 
 	for _, option := range options {
 		option(s)
@@ -55,8 +105,16 @@ func (s *queueServer) Start() error {
 		return nil
 	}
 
+	if err := s.applyconfig(); err != nil {
+		return err
+	}
+
 	go s.sm.Start()
 
+	if s.repl != nil {
+		go s.repl.run()
+	}
+
 	defer close(s.sm.ch)
 
 	return s.serve()
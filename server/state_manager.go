@@ -9,14 +9,16 @@ import (
 
 type statemanager struct {
 	// Define fields for the StatsManager struct
-	qm *queuemanager
-	ch chan string
+	qm      *queuemanager
+	ch      chan string
+	limiter *ratelimiter
 }
 
 func NewStateManager(qm *queuemanager) *statemanager {
 	return &statemanager{
-		qm: qm,
-		ch: make(chan string, 100),
+		qm:      qm,
+		ch:      make(chan string, 100),
+		limiter: newratelimiter(qm),
 	}
 }
 
@@ -46,23 +48,23 @@ func (sm *statemanager) AddStat(appname, command, channel string) error {
 }
 
 func (sm *statemanager) Stats(appname string) ([]*tinyq.ChannelStats, error) {
-	primaryq, _ := sm.qm.Get(appname)
-	channels, err := primaryq.ListChannels()
+	primaryq, err := sm.qm.Get(appname)
+	if err != nil {
+		return nil, err
+	}
 
-	s, err := sm.qm.Get("states")
+	stats, err := primaryq.Stats(appname)
 	if err != nil {
 		return nil, err
 	}
 
-	var stats []*tinyq.ChannelStats
+	s, err := sm.qm.Get("states")
+	if err != nil {
+		return nil, err
+	}
 
-	for channel, count := range channels {
-		ispaused, _ := s.IsChannelPaused(appname + ":" + channel)
-		stats = append(stats, &tinyq.ChannelStats{
-			Channel:  channel,
-			Count:    count,
-			IsPaused: ispaused,
-		})
+	for _, one := range stats {
+		one.IsPaused, _ = s.IsChannelPaused(appname + ":" + one.Channel)
 	}
 
 	return stats, nil
@@ -91,6 +93,10 @@ func (sm *statemanager) IsChannelLocked(appname, channel string) (bool, error) {
 	}
 
 	v, err := q.Get("__locks__", appname+":"+channel)
+	if err == tinyq.ErrBucketNotFound {
+		// Nothing was ever locked.
+		return false, nil
+	}
 
 	return v == "locked", err
 }
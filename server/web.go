@@ -48,8 +48,32 @@ type queuecontext struct {
 	*blueweb.Context
 	qm      *queuemanager
 	sm      *statemanager
+	admin   *admin
+	repl    *replicator
 	Appname string
+	token   string
 	q       tinyq.TinyQ
+	// release lets the app be swapped before the request ends, for requests
+	// that wait or swap it themselves. q must not be used afterwards.
+	release func()
+}
+
+// throttledresponse answers a pop refused by the rate limit of its channel.
+// The client reads it into a ThrottledError.
+type throttledresponse struct {
+	Message    string `json:"message"`
+	RetryAfter string `json:"retry_after"`
+}
+
+// errorresponse is the body of a failed request.
+type errorresponse struct {
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+// sendError answers with err, encoded so that any error text stays valid JSON.
+func (qc *queuecontext) sendError(err error) {
+	qc.Json(&errorresponse{Message: "error", Error: err.Error()})
 }
 
 func (qc *queuecontext) sendOk(message string, err ...error) {
@@ -79,6 +103,8 @@ func (s *queueServer) serve() error {
 				Context: ctx,
 				qm:      s.qm,
 				sm:      s.sm,
+				admin:   s.admin,
+				repl:    s.repl,
 			}
 
 			appname := ctx.Query("app")
@@ -89,20 +115,23 @@ func (s *queueServer) serve() error {
 			qctx.Appname = appname
 
 			token := ctx.Query("token")
+			qctx.token = token
 
 			if len(token) > 0 {
 				fmt.Println(token)
 			}
 
-			q, err := s.qm.Get(appname)
+			q, release, err := s.qm.Acquire(appname)
 
 			if err != nil {
 				fmt.Println(err)
 				ctx.Status(http.StatusInternalServerError)
 				return
 			}
+			defer release()
 
 			qctx.q = q
+			qctx.release = release
 
 			fn(qctx)
 
@@ -196,7 +225,13 @@ func (s *queueServer) serve() error {
 	tinyqapi.Get("/", view_index)
 	tinyqapi.Get("/crud/:cmd/:key", middle(crud_endpoint))
 	tinyqapi.Get("/push", middle(push_endpoint))
+	tinyqapi.Post("/push", middle(push_endpoint))
+	tinyqapi.Post("/push/batch", middle(push_batch_endpoint))
 	tinyqapi.Get("/pop", middle(pop_endpoint))
+	tinyqapi.Get("/ack", middle(ack_endpoint))
+	tinyqapi.Post("/ack", middle(ack_endpoint))
+	tinyqapi.Get("/nack", middle(nack_endpoint))
+	tinyqapi.Post("/nack", middle(nack_endpoint))
 	tinyqapi.Get("/channels", middle(channels_endpoint))
 	// tinyqapi.Get("/app/secure", middle(app_secure_endpoint))
 	// tinyqapi.Get("/app/open", middle(app_open_endpoint))
@@ -209,8 +244,31 @@ func (s *queueServer) serve() error {
 	tinyqapi.Get("/channels/lock", middle(channel_lock_endpoint))
 	tinyqapi.Get("/channels/unlock", middle(channel_unlock_endpoint))
 	tinyqapi.Get("/channels/lockstatus", middle(channel_lock_status_endpoint))
+	tinyqapi.Get("/channels/ttl", middle(channels_ttl_endpoint))
+	tinyqapi.Get("/channels/items", middle(channels_items_endpoint))
+	tinyqapi.Get("/channels/config", middle(channels_config_endpoint))
+	tinyqapi.Get("/channels/ratelimit", middle(channels_ratelimit_endpoint))
+
+	tinyqapi.Get("/routes", middle(routes_endpoint))
+	tinyqapi.Get("/routes/set", middle(routes_set_endpoint))
+	tinyqapi.Post("/routes/set", middle(routes_set_endpoint))
+	tinyqapi.Get("/routes/delete", middle(routes_delete_endpoint))
+	tinyqapi.Get("/routes/test", middle(routes_test_endpoint))
+	tinyqapi.Post("/routes/test", middle(routes_test_endpoint))
+
+	tinyqapi.Get("/topics", middle(topics_endpoint))
+	tinyqapi.Get("/topics/bind", middle(topics_bind_endpoint))
+	tinyqapi.Get("/topics/unbind", middle(topics_unbind_endpoint))
+	tinyqapi.Get("/topics/publish", middle(topics_publish_endpoint))
+	tinyqapi.Post("/topics/publish", middle(topics_publish_endpoint))
+
+	tinyqapi.Get("/dlq", middle(deadletters_endpoint))
+	tinyqapi.Get("/dlq/requeue", middle(deadletters_requeue_endpoint))
+	tinyqapi.Get("/dlq/purge", middle(deadletters_purge_endpoint))
 
 	tinyqapi.Get("/stats", middle(stats_endpoint))
+	tinyqapi.Get("/stats/replication", middle(replication_stats_endpoint))
+	tinyqapi.Get("/replication/changes", middle(replication_changes_endpoint))
 	tinyqapi.Get("/databases", middle(databases_endpoint))
 
 	// tinyqapi.After(func(ctx *blueweb.Context) bool {
@@ -224,6 +282,14 @@ func (s *queueServer) serve() error {
 		ctx.Status(http.StatusOK)
 		ctx.String("Admin Page")
 	})
+	adminapi.Get("/move", middle(admin_move_endpoint))
+	adminapi.Get("/token", middle(admin_token_endpoint))
+	adminapi.Get("/backup", middle(admin_backup_endpoint))
+	adminapi.Post("/restore", middle(admin_restore_endpoint))
+	adminapi.Get("/compact", middle(admin_compact_endpoint))
+	adminapi.Get("/export", middle(admin_export_endpoint))
+	adminapi.Post("/import", middle(admin_import_endpoint))
+	adminapi.Get("/promote", middle(admin_promote_endpoint))
 
 	web.Config().SetDev(s.logging).SetPort(s.port).StopOnInterrupt()
 	fmt.Println("Server Started")
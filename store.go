@@ -0,0 +1,146 @@
+package tinyq
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Store is the storage engine of a TinyQ. Channels, items, KV buckets and
+// counters are all kept in named buckets of ordered keys, read and written in
+// transactions. The queue itself only depends on these interfaces, so a
+// driver can back it with any engine that offers the same guarantees: a write
+// transaction is atomic and isolated from every other transaction.
+type Store interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(Tx) error) error
+	// Update runs fn in a read-write transaction, committed when fn returns
+	// nil and rolled back otherwise.
+	Update(fn func(Tx) error) error
+	// Batch is like Update, but may commit fn together with concurrent calls
+	// in a single transaction. fn may run more than once and must only have
+	// effects through the transaction.
+	Batch(fn func(Tx) error) error
+	Close() error
+}
+
+// Tx is a transaction of a Store. Buckets and the keys and values read from
+// them are only valid until the transaction ends.
+type Tx interface {
+	// Bucket returns nil when the bucket does not exist.
+	Bucket(name []byte) Bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	// DeleteBucket returns ErrBucketNotFound when the bucket does not exist.
+	DeleteBucket(name []byte) error
+	ForEach(fn func(name []byte, b Bucket) error) error
+	Writable() bool
+	// OnCommit runs fn once the transaction is committed.
+	OnCommit(fn func())
+}
+
+// Bucket is a set of keys kept in byte order.
+type Bucket interface {
+	// Get returns nil when the key does not exist.
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Cursor() Cursor
+	ForEach(fn func(k, v []byte) error) error
+	NextSequence() (uint64, error)
+	SetSequence(v uint64) error
+	// KeyN returns the number of keys.
+	KeyN() int
+}
+
+// Cursor iterates over the keys of a bucket in byte order. A nil key means
+// the cursor moved past either end of the bucket.
+type Cursor interface {
+	First() (key, value []byte)
+	Last() (key, value []byte)
+	Next() (key, value []byte)
+	Prev() (key, value []byte)
+	// Seek moves to the first key at or after seek.
+	Seek(seek []byte) (key, value []byte)
+	// Delete removes the key the cursor is on.
+	Delete() error
+}
+
+// DriverFunc opens the store of an app.
+type DriverFunc func(opt *Options) (Store, error)
+
+// DriverBolt keeps every app in its own bbolt file under the root path. It is
+// the default driver.
+const DriverBolt = "bolt"
+
+// Durability decides when the writes of an app reach the disk.
+type Durability string
+
+const (
+	// DurabilitySync syncs every commit to disk before it returns. It is the
+	// default.
+	DurabilitySync Durability = "sync"
+	// DurabilityBatch commits concurrent pushes together, with one sync per
+	// group. A push still returns only once it is on disk.
+	DurabilityBatch Durability = "batch"
+	// DurabilityNone never syncs, leaving it to the operating system. A
+	// crash loses the latest writes, which suits scratch apps.
+	DurabilityNone Durability = "none"
+)
+
+var ErrBucketExists = errors.New("bucket already exists")
+
+// ErrNotSupported is returned for an operation the storage driver of an app
+// can't do, such as a backup of an app kept in memory.
+var ErrNotSupported = errors.New("not supported by the storage driver")
+
+// snapshotter is implemented by stores that can write a consistent copy of
+// themselves while being used.
+type snapshotter interface {
+	Snapshot(w io.Writer) (int64, error)
+}
+
+var (
+	driverslock sync.RWMutex
+	drivers     = make(map[string]DriverFunc)
+)
+
+// RegisterDriver makes a storage driver available to Options.Driver.
+func RegisterDriver(name string, open DriverFunc) {
+	driverslock.Lock()
+	defer driverslock.Unlock()
+
+	drivers[name] = open
+}
+
+func hasdriver(name string) bool {
+	driverslock.RLock()
+	defer driverslock.RUnlock()
+
+	_, ok := drivers[name]
+	return ok
+}
+
+func openstore(opt *Options) (Store, error) {
+	name := opt.Driver
+	if len(name) == 0 {
+		name = DriverBolt
+	}
+
+	switch opt.Durability {
+	case "", DurabilitySync, DurabilityBatch, DurabilityNone:
+	default:
+		return nil, fmt.Errorf("unknown durability %q", opt.Durability)
+	}
+
+	driverslock.RLock()
+	open, ok := drivers[name]
+	driverslock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %q", name)
+	}
+
+	return open(opt)
+}
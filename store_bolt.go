@@ -0,0 +1,291 @@
+package tinyq
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func init() {
+	RegisterDriver(DriverBolt, openbolt)
+}
+
+func openbolt(opt *Options) (Store, error) {
+	// The root path is only created once an app is stored in it.
+	if err := createifnotexists(opt.Root()); err != nil {
+		return nil, err
+	}
+
+	db, err := bbolt.Open(DBPath(opt), 0600, &bbolt.Options{NoSync: opt.Durability == DurabilityNone})
+	if err != nil {
+		return nil, err
+	}
+
+	// A group is committed as soon as it is full or its first push has waited
+	// this long, which bounds what batching adds to the latency of a push.
+	db.MaxBatchDelay = batchDelay
+
+	return &boltstore{db: db}, nil
+}
+
+// batchDelay is how long a batched push waits for others to join its group.
+const batchDelay = 2 * time.Millisecond
+
+// DBPath returns the file of an app stored by the bolt driver.
+func DBPath(opt *Options) string {
+	return filepath.Join(opt.Root(), opt.Appname+".db")
+}
+
+// StageRestore writes a snapshot made by Backup next to the file of an app
+// and checks that it is a valid database. It returns the path of the staged
+// file, to be renamed over DBPath once the app is closed.
+func StageRestore(opt *Options, r io.Reader) (string, error) {
+	if err := createifnotexists(opt.Root()); err != nil {
+		return "", err
+	}
+
+	staged := DBPath(opt) + ".restore"
+	f, err := os.OpenFile(staged, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = checkbolt(staged)
+	}
+
+	if err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+
+	return staged, nil
+}
+
+// compactTxSize is how many bytes Compact copies per transaction.
+const compactTxSize = 64 << 20
+
+// Compact rewrites the file of an app without its free pages and swaps it
+// in. The app must be closed. It returns the size of the file before and after.
+func Compact(opt *Options) (int64, int64, error) {
+	path := DBPath(opt)
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// A file left over by an interrupted compaction is started over.
+	compacted := path + ".compact"
+	os.Remove(compacted)
+
+	if err := compactbolt(compacted, path); err != nil {
+		os.Remove(compacted)
+		return info.Size(), 0, err
+	}
+
+	after, err := os.Stat(compacted)
+	if err != nil {
+		return info.Size(), 0, err
+	}
+
+	if err := os.Rename(compacted, path); err != nil {
+		os.Remove(compacted)
+		return info.Size(), 0, err
+	}
+
+	return info.Size(), after.Size(), nil
+}
+
+func compactbolt(dstpath, srcpath string) error {
+	src, err := bbolt.Open(srcpath, 0600, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := bbolt.Open(dstpath, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := bbolt.Compact(dst, src, compactTxSize); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+func checkbolt(path string) error {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		// Every error must be received for the check to finish.
+		var first error
+		for err := range tx.Check() {
+			if first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+}
+
+type boltstore struct {
+	db *bbolt.DB
+}
+
+func (s *boltstore) View(fn func(Tx) error) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		return fn(&bolttx{tx: tx})
+	})
+}
+
+func (s *boltstore) Update(fn func(Tx) error) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return fn(&bolttx{tx: tx})
+	})
+}
+
+func (s *boltstore) Batch(fn func(Tx) error) error {
+	return s.db.Batch(func(tx *bbolt.Tx) error {
+		return fn(&bolttx{tx: tx})
+	})
+}
+
+// Snapshot writes the database file as seen by a read transaction, so
+// writers are not blocked while it is copied.
+func (s *boltstore) Snapshot(w io.Writer) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+
+	return n, err
+}
+
+func (s *boltstore) Close() error {
+	return s.db.Close()
+}
+
+type bolttx struct {
+	tx *bbolt.Tx
+}
+
+// wrapbucket keeps a missing bucket a nil interface.
+func wrapbucket(b *bbolt.Bucket) Bucket {
+	if b == nil {
+		return nil
+	}
+
+	return &boltbucket{b: b}
+}
+
+func bolterror(err error) error {
+	switch err {
+	case bbolt.ErrBucketNotFound:
+		return ErrBucketNotFound
+	case bbolt.ErrBucketExists:
+		return ErrBucketExists
+	}
+
+	return err
+}
+
+func (t *bolttx) Bucket(name []byte) Bucket {
+	return wrapbucket(t.tx.Bucket(name))
+}
+
+func (t *bolttx) CreateBucket(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	return wrapbucket(b), bolterror(err)
+}
+
+func (t *bolttx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	return wrapbucket(b), bolterror(err)
+}
+
+func (t *bolttx) DeleteBucket(name []byte) error {
+	return bolterror(t.tx.DeleteBucket(name))
+}
+
+func (t *bolttx) ForEach(fn func(name []byte, b Bucket) error) error {
+	return t.tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		return fn(name, wrapbucket(b))
+	})
+}
+
+func (t *bolttx) Writable() bool {
+	return t.tx.Writable()
+}
+
+func (t *bolttx) OnCommit(fn func()) {
+	t.tx.OnCommit(fn)
+}
+
+type boltbucket struct {
+	b *bbolt.Bucket
+}
+
+func (b *boltbucket) Get(key []byte) []byte {
+	return b.b.Get(key)
+}
+
+func (b *boltbucket) Put(key, value []byte) error {
+	return b.b.Put(key, value)
+}
+
+func (b *boltbucket) Delete(key []byte) error {
+	return b.b.Delete(key)
+}
+
+func (b *boltbucket) Cursor() Cursor {
+	return b.b.Cursor()
+}
+
+func (b *boltbucket) ForEach(fn func(k, v []byte) error) error {
+	return b.b.ForEach(fn)
+}
+
+func (b *boltbucket) NextSequence() (uint64, error) {
+	return b.b.NextSequence()
+}
+
+func (b *boltbucket) SetSequence(v uint64) error {
+	return b.b.SetSequence(v)
+}
+
+func (b *boltbucket) KeyN() int {
+	if !b.b.Writable() {
+		return b.b.Stats().KeyN
+	}
+
+	// Stats only reads committed pages, a write transaction counts its own
+	// changes too.
+	var n int
+	c := b.b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+
+	return n
+}
@@ -0,0 +1,59 @@
+package tinyq
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCompact(t *testing.T) {
+	opt := &Options{Appname: "queue", Rootpath: t.TempDir(), MaxPopCount: 100, Durability: DurabilityNone}
+
+	q := NewTinyQ(opt)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Most of many large items are delivered, leaving free pages behind.
+	payload := fmt.Sprintf("%01024d", 0)
+	items := make([]*Item, 2000)
+	for i := range items {
+		items[i] = &Item{Channel: "jobs", Key: fmt.Sprintf("k%04d", i), Payload: []byte(payload)}
+	}
+	if _, err := q.PushBatch(items); err != nil {
+		t.Fatal(err)
+	}
+	for range 19 {
+		popped, err := q.PopItems("jobs", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, item := range popped {
+			if err := q.AckItem(item); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	before, after, err := Compact(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after >= before {
+		t.Fatalf("compacted %d bytes into %d", before, after)
+	}
+
+	q = openapp(t, opt)
+	if n := count(t, q, "jobs"); n != 100 {
+		t.Fatalf("jobs holds %d items after compacting, want 100", n)
+	}
+
+	items, err = q.PopItems("jobs", 1)
+	if err != nil || len(items) != 1 || items[0].Key != "k1900" || string(items[0].Payload) != payload {
+		t.Fatalf("popped %v after compacting: %v", items, err)
+	}
+}
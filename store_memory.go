@@ -0,0 +1,436 @@
+package tinyq
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/google/btree"
+)
+
+// DriverMemory keeps an app in memory only. It has the semantics of the bolt
+// driver, but its items are gone once the queue is closed, which suits tests
+// and scratch queues that should not survive a restart.
+const DriverMemory = "memory"
+
+var errTxClosed = errors.New("transaction is closed")
+
+func init() {
+	RegisterDriver(DriverMemory, openmemory)
+}
+
+func openmemory(opt *Options) (Store, error) {
+	return &memorystore{buckets: make(map[string]*memorybucket)}, nil
+}
+
+// NewMemoryTinyQ returns a TinyQ that keeps its items in memory only.
+func NewMemoryTinyQ(opt *Options) TinyQ {
+	o := Options{}
+	if opt != nil {
+		o = *opt
+	}
+	o.Driver = DriverMemory
+
+	return NewTinyQ(&o)
+}
+
+// memorystore runs one write transaction at a time, like bbolt. A write
+// transaction applies its changes right away and keeps an undo log to roll
+// them back when it fails.
+type memorystore struct {
+	lock    sync.RWMutex
+	buckets map[string]*memorybucket
+}
+
+func (s *memorystore) View(fn func(Tx) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	tx := &memorytx{store: s}
+	defer tx.close()
+
+	return fn(tx)
+}
+
+func (s *memorystore) Update(fn func(Tx) error) (err error) {
+	s.lock.Lock()
+	tx := &memorytx{store: s, writable: true}
+
+	committed := false
+	defer func() {
+		if !committed {
+			tx.rollback()
+		}
+		tx.close()
+		s.lock.Unlock()
+
+		if committed {
+			for _, fn := range tx.oncommit {
+				fn()
+			}
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	committed = true
+	return nil
+}
+
+// Batch has nothing to gain from grouping writes in memory.
+func (s *memorystore) Batch(fn func(Tx) error) error {
+	return s.Update(fn)
+}
+
+func (s *memorystore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.buckets = make(map[string]*memorybucket)
+	return nil
+}
+
+type memorytx struct {
+	store    *memorystore
+	writable bool
+	closed   bool
+	undo     []func()
+	oncommit []func()
+}
+
+func (t *memorytx) close() {
+	t.closed = true
+}
+
+func (t *memorytx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		t.undo[i]()
+	}
+}
+
+func (t *memorytx) check() error {
+	if t.closed {
+		return errTxClosed
+	}
+
+	if !t.writable {
+		return errors.New("transaction is read-only")
+	}
+
+	return nil
+}
+
+func (t *memorytx) Bucket(name []byte) Bucket {
+	b, ok := t.store.buckets[string(name)]
+	if !ok {
+		return nil
+	}
+
+	return &memorybucketref{tx: t, b: b}
+}
+
+func (t *memorytx) CreateBucket(name []byte) (Bucket, error) {
+	if err := t.check(); err != nil {
+		return nil, err
+	}
+
+	if len(name) == 0 {
+		return nil, errors.New("bucket name required")
+	}
+
+	key := string(name)
+	if _, ok := t.store.buckets[key]; ok {
+		return nil, ErrBucketExists
+	}
+
+	b := newmemorybucket()
+	t.store.buckets[key] = b
+	t.undo = append(t.undo, func() { delete(t.store.buckets, key) })
+
+	return &memorybucketref{tx: t, b: b}, nil
+}
+
+func (t *memorytx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if b := t.Bucket(name); b != nil {
+		return b, t.check()
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *memorytx) DeleteBucket(name []byte) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+
+	key := string(name)
+	b, ok := t.store.buckets[key]
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	delete(t.store.buckets, key)
+	t.undo = append(t.undo, func() { t.store.buckets[key] = b })
+
+	return nil
+}
+
+func (t *memorytx) ForEach(fn func(name []byte, b Bucket) error) error {
+	names := make([]string, 0, len(t.store.buckets))
+	for name := range t.store.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		b, ok := t.store.buckets[name]
+		if !ok {
+			continue
+		}
+
+		if err := fn([]byte(name), &memorybucketref{tx: t, b: b}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *memorytx) Writable() bool {
+	return t.writable
+}
+
+func (t *memorytx) OnCommit(fn func()) {
+	t.oncommit = append(t.oncommit, fn)
+}
+
+// memoryDegree is the degree of the B-tree that orders the keys of a bucket.
+const memoryDegree = 32
+
+// memorybucket keeps its keys sorted in a B-tree next to a map of their
+// values, so keys are put and deleted in logarithmic time however large the
+// bucket grows. Go compares strings byte by byte, the order of bbolt.
+type memorybucket struct {
+	keys     *btree.BTreeG[string]
+	values   map[string][]byte
+	sequence uint64
+}
+
+func newmemorybucket() *memorybucket {
+	return &memorybucket{keys: btree.NewOrderedG[string](memoryDegree), values: make(map[string][]byte)}
+}
+
+func (b *memorybucket) set(key string, value []byte) {
+	if _, ok := b.values[key]; !ok {
+		b.keys.ReplaceOrInsert(key)
+	}
+
+	b.values[key] = value
+}
+
+func (b *memorybucket) unset(key string) {
+	if _, ok := b.values[key]; !ok {
+		return
+	}
+
+	b.keys.Delete(key)
+	delete(b.values, key)
+}
+
+// ceil returns the first key at or after key.
+func (b *memorybucket) ceil(key string) (string, bool) {
+	var found string
+	var ok bool
+	b.keys.AscendGreaterOrEqual(key, func(k string) bool {
+		found, ok = k, true
+		return false
+	})
+
+	return found, ok
+}
+
+// after returns the first key after key.
+func (b *memorybucket) after(key string) (string, bool) {
+	var found string
+	var ok bool
+	b.keys.AscendGreaterOrEqual(key, func(k string) bool {
+		if k == key {
+			return true
+		}
+
+		found, ok = k, true
+		return false
+	})
+
+	return found, ok
+}
+
+// before returns the last key before key.
+func (b *memorybucket) before(key string) (string, bool) {
+	var found string
+	var ok bool
+	b.keys.DescendLessOrEqual(key, func(k string) bool {
+		if k == key {
+			return true
+		}
+
+		found, ok = k, true
+		return false
+	})
+
+	return found, ok
+}
+
+// memorybucketref is a bucket as seen by a transaction.
+type memorybucketref struct {
+	tx *memorytx
+	b  *memorybucket
+}
+
+func (r *memorybucketref) Get(key []byte) []byte {
+	return r.b.values[string(key)]
+}
+
+func (r *memorybucketref) Put(key, value []byte) error {
+	if err := r.tx.check(); err != nil {
+		return err
+	}
+
+	if len(key) == 0 {
+		return errors.New("key required")
+	}
+
+	k, b := string(key), r.b
+	previous, existed := b.values[k]
+	b.set(k, bytes.Clone(value))
+	if b.values[k] == nil {
+		// A nil value would read back as a missing key.
+		b.values[k] = []byte{}
+	}
+
+	r.tx.undo = append(r.tx.undo, func() {
+		if existed {
+			b.set(k, previous)
+		} else {
+			b.unset(k)
+		}
+	})
+
+	return nil
+}
+
+func (r *memorybucketref) Delete(key []byte) error {
+	if err := r.tx.check(); err != nil {
+		return err
+	}
+
+	k, b := string(key), r.b
+	previous, existed := b.values[k]
+	if !existed {
+		return nil
+	}
+
+	b.unset(k)
+	r.tx.undo = append(r.tx.undo, func() { b.set(k, previous) })
+
+	return nil
+}
+
+func (r *memorybucketref) Cursor() Cursor {
+	return &memorycursor{ref: r}
+}
+
+func (r *memorybucketref) ForEach(fn func(k, v []byte) error) error {
+	c := r.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *memorybucketref) NextSequence() (uint64, error) {
+	if err := r.tx.check(); err != nil {
+		return 0, err
+	}
+
+	b := r.b
+	b.sequence++
+	r.tx.undo = append(r.tx.undo, func() { b.sequence-- })
+
+	return b.sequence, nil
+}
+
+func (r *memorybucketref) SetSequence(v uint64) error {
+	if err := r.tx.check(); err != nil {
+		return err
+	}
+
+	b, previous := r.b, r.b.sequence
+	b.sequence = v
+	r.tx.undo = append(r.tx.undo, func() { b.sequence = previous })
+
+	return nil
+}
+
+func (r *memorybucketref) KeyN() int {
+	return r.b.keys.Len()
+}
+
+// memorycursor remembers the key it is on rather than a position, so keys
+// put or deleted while iterating don't make it skip or repeat keys.
+type memorycursor struct {
+	ref *memorybucketref
+	key []byte
+}
+
+func (c *memorycursor) at(key string, ok bool) ([]byte, []byte) {
+	if !ok {
+		c.key = nil
+		return nil, nil
+	}
+
+	c.key = []byte(key)
+	return c.key, c.ref.b.values[key]
+}
+
+func (c *memorycursor) First() ([]byte, []byte) {
+	return c.at(c.ref.b.keys.Min())
+}
+
+func (c *memorycursor) Last() ([]byte, []byte) {
+	return c.at(c.ref.b.keys.Max())
+}
+
+func (c *memorycursor) Next() ([]byte, []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+
+	return c.at(c.ref.b.after(string(c.key)))
+}
+
+func (c *memorycursor) Prev() ([]byte, []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+
+	return c.at(c.ref.b.before(string(c.key)))
+}
+
+func (c *memorycursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.at(c.ref.b.ceil(string(seek)))
+}
+
+func (c *memorycursor) Delete() error {
+	if c.key == nil {
+		return nil
+	}
+
+	return c.ref.Delete(c.key)
+}
@@ -0,0 +1,386 @@
+package tinyq
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// storecases hold the behavior every storage driver must share with bolt.
+var storecases = []struct {
+	name string
+	run  func(t *testing.T, db Store)
+}{
+	{"keys in byte order", testStoreOrder},
+	{"rollback on error", testStoreRollback},
+	{"delete while iterating", testStoreCursorDelete},
+	{"sequence undone on rollback", testStoreSequence},
+	{"buckets", testStoreBuckets},
+}
+
+func TestStoreDrivers(t *testing.T) {
+	for _, driver := range []string{DriverBolt, DriverMemory} {
+		for _, tc := range storecases {
+			t.Run(driver+"/"+tc.name, func(t *testing.T) {
+				db, err := openstore(&Options{Appname: "store", Rootpath: t.TempDir(), Driver: driver})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+
+				tc.run(t, db)
+			})
+		}
+	}
+}
+
+// TestQueueDrivers pops items in the same order whatever the driver.
+func TestQueueDrivers(t *testing.T) {
+	for _, driver := range []string{DriverBolt, DriverMemory} {
+		t.Run(driver, func(t *testing.T) {
+			q := NewTinyQ(&Options{Appname: "queue", Rootpath: t.TempDir(), Driver: driver})
+			if err := q.Open(); err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+
+			push(t, q, "jobs", "a", "a")
+			push(t, q, "jobs", "b", "b", WithPriority(5))
+			push(t, q, "jobs", "c", "c")
+			push(t, q, "jobs", "d", "d", WithPriority(5))
+
+			items, err := q.PopItems("jobs", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var keys string
+			for _, item := range items {
+				keys += item.Key
+			}
+
+			if keys != "bdac" {
+				t.Fatalf("popped %q, want %q", keys, "bdac")
+			}
+		})
+	}
+}
+
+// TestDriverSelection opens an app with the driver named by its options.
+func TestDriverSelection(t *testing.T) {
+	var opened int
+	RegisterDriver("counting", func(opt *Options) (Store, error) {
+		opened++
+		return openmemory(opt)
+	})
+
+	q := NewTinyQ(&Options{Appname: "queue", Driver: "counting"})
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if opened != 1 {
+		t.Fatalf("driver opened %d stores, want 1", opened)
+	}
+
+	push(t, q, "jobs", "a", "a")
+	if n := count(t, q, "jobs"); n != 1 {
+		t.Fatalf("jobs holds %d items, want 1", n)
+	}
+
+	if err := NewTinyQ(&Options{Appname: "queue", Driver: "missing"}).Open(); err == nil {
+		t.Fatal("opened an app with an unknown driver")
+	}
+}
+
+// TestBoltReopen keeps the items of an app stored by the default driver
+// once it is closed.
+func TestBoltReopen(t *testing.T) {
+	opt := &Options{Appname: "queue", Rootpath: t.TempDir()}
+
+	q := NewTinyQ(opt)
+	if err := q.Open(); err != nil {
+		t.Fatal(err)
+	}
+	push(t, q, "jobs", "a", "one")
+	q.Close()
+
+	q = openapp(t, opt)
+	if got := drain(t, q, "jobs"); len(got) != 1 || got["a"] != "one" {
+		t.Fatalf("jobs holds %v after reopening", got)
+	}
+}
+
+func put(t *testing.T, db Store, bucket string, keys ...string) {
+	t.Helper()
+
+	err := db.Update(func(tx Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := b.Put([]byte(key), []byte("v"+key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func keys(t *testing.T, db Store, bucket string) string {
+	t.Helper()
+
+	var got string
+	err := db.View(func(tx Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			got += string(k) + " "
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return got
+}
+
+func testStoreOrder(t *testing.T, db Store) {
+	put(t, db, "b", "c", "a", "b", "ab")
+
+	if got := keys(t, db, "b"); got != "a ab b c " {
+		t.Fatalf("keys %q", got)
+	}
+
+	err := db.View(func(tx Tx) error {
+		c := tx.Bucket([]byte("b")).Cursor()
+
+		if k, v := c.Seek([]byte("aa")); string(k) != "ab" || string(v) != "vab" {
+			t.Errorf("seek aa: %q %q", k, v)
+		}
+
+		if k, _ := c.Prev(); string(k) != "a" {
+			t.Errorf("prev of ab: %q", k)
+		}
+
+		if k, _ := c.Prev(); k != nil {
+			t.Errorf("prev of a: %q", k)
+		}
+
+		if k, _ := c.Last(); string(k) != "c" {
+			t.Errorf("last: %q", k)
+		}
+
+		if k, _ := c.Seek([]byte("d")); k != nil {
+			t.Errorf("seek past the end: %q", k)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreRollback(t *testing.T, db Store) {
+	put(t, db, "b", "a", "b")
+
+	failed := errors.New("failed")
+	err := db.Update(func(tx Tx) error {
+		b := tx.Bucket([]byte("b"))
+		if err := b.Put([]byte("c"), []byte("vc")); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("a"), []byte("changed")); err != nil {
+			return err
+		}
+		if err := b.Delete([]byte("b")); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucket([]byte("new")); err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket([]byte("b")); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("update returned %v", err)
+	}
+
+	if got := keys(t, db, "b"); got != "a b " {
+		t.Fatalf("keys %q after rollback", got)
+	}
+
+	err = db.View(func(tx Tx) error {
+		if v := tx.Bucket([]byte("b")).Get([]byte("a")); string(v) != "va" {
+			t.Errorf("a is %q after rollback", v)
+		}
+
+		if tx.Bucket([]byte("new")) != nil {
+			t.Error("created bucket kept after rollback")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreCursorDelete(t *testing.T, db Store) {
+	var all []string
+	for i := 0; i < 10; i++ {
+		all = append(all, fmt.Sprint(i))
+	}
+	put(t, db, "b", all...)
+
+	// Delete the even keys with the cursor, then the rest the way the queue
+	// drains a bucket: always from the first key.
+	err := db.Update(func(tx Tx) error {
+		c := tx.Bucket([]byte("b")).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Seek(k) {
+			if k[0]%2 == 0 {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if k, _ = c.Next(); k == nil {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := keys(t, db, "b"); got != "1 3 5 7 9 " {
+		t.Fatalf("keys %q after deleting the even ones", got)
+	}
+
+	err = db.Update(func(tx Tx) error {
+		b := tx.Bucket([]byte("b"))
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.First() {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		if n := b.KeyN(); n != 0 {
+			t.Errorf("%d keys left", n)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStoreSequence(t *testing.T, db Store) {
+	next := func(fail bool) uint64 {
+		var seq uint64
+		err := db.Update(func(tx Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("b"))
+			if err != nil {
+				return err
+			}
+
+			if seq, err = b.NextSequence(); err != nil {
+				return err
+			}
+
+			if fail {
+				return errors.New("failed")
+			}
+			return nil
+		})
+		if fail != (err != nil) {
+			t.Fatalf("update returned %v", err)
+		}
+
+		return seq
+	}
+
+	if seq := next(false); seq != 1 {
+		t.Fatalf("first sequence %d", seq)
+	}
+
+	if seq := next(true); seq != 2 {
+		t.Fatalf("rolled back sequence %d", seq)
+	}
+
+	if seq := next(false); seq != 2 {
+		t.Fatalf("sequence %d after a rollback, want 2", seq)
+	}
+
+	err := db.Update(func(tx Tx) error {
+		return tx.Bucket([]byte("b")).SetSequence(10)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if seq := next(false); seq != 11 {
+		t.Fatalf("sequence %d after setting it to 10", seq)
+	}
+}
+
+func testStoreBuckets(t *testing.T, db Store) {
+	err := db.Update(func(tx Tx) error {
+		if _, err := tx.CreateBucket([]byte("b")); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucket([]byte("b")); err != ErrBucketExists {
+			t.Errorf("creating an existing bucket returned %v", err)
+		}
+
+		if err := tx.DeleteBucket([]byte("missing")); err != ErrBucketNotFound {
+			t.Errorf("deleting a missing bucket returned %v", err)
+		}
+
+		if _, err := tx.CreateBucket([]byte("a")); err != nil {
+			return err
+		}
+
+		var names string
+		err := tx.ForEach(func(name []byte, b Bucket) error {
+			names += string(name) + " "
+			return nil
+		})
+		if names != "a b " {
+			t.Errorf("buckets %q", names)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.View(func(tx Tx) error {
+		if tx.Writable() {
+			t.Error("view is writable")
+		}
+
+		if _, err := tx.CreateBucket([]byte("c")); err == nil {
+			t.Error("bucket created in a view")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
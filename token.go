@@ -198,7 +198,7 @@ func shiftNumber(str string) string {
 			n, _ := strconv.Atoi(string(r))
 
 			// fmt.Println(65 + n)
-			result += string(65 + n)
+			result += string(rune(65 + n))
 		} else {
 			result += string(r)
 		}
@@ -7,6 +7,7 @@ import (
 )
 
 var ErrNotFound = errors.New("not found")
+var ErrBucketNotFound = errors.New("bucket not found")
 
 type BoltWrapper struct {
 	db     *bbolt.DB